@@ -1,13 +1,76 @@
 package client
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/xanzy/go-gitlab"
 )
 
 const MR_MERGE_STATUS_MERGEABLE = "mergeable"
+const MR_MERGE_STATUS_CI_STILL_RUNNING = "ci_still_running"
+const MR_MERGE_STATUS_NEED_REBASE = "need_rebase"
+const MR_MERGE_STATUS_CONFLICT = "conflict"
+
+// transientMergeStatuses are merge statuses which are expected to change without any action on the MR.
+var transientMergeStatuses = []string{"unchecked", "checking", "preparing", "approvals_syncing", MR_MERGE_STATUS_CI_STILL_RUNNING}
+
+const MR_STATE_MERGED = "merged"
+const MR_STATE_OPENED = "opened"
+
+const (
+	PIPELINE_STATUS_SUCCESS = "success"
+	PIPELINE_STATUS_SKIPPED = "skipped"
+)
+
+// Config refs specify from which branch the config file of a MR is read.
+const (
+	CONFIG_REF_SOURCE         = "source"
+	CONFIG_REF_TARGET         = "target"
+	CONFIG_REF_DEFAULT_BRANCH = "default-branch"
+)
+
+var ConfigRefs = []string{CONFIG_REF_SOURCE, CONFIG_REF_TARGET, CONFIG_REF_DEFAULT_BRANCH}
+
+// ConfigLocation identifies the branch of a project from which config files are read.
+type ConfigLocation struct {
+	ProjectID   int
+	ProjectPath string
+	Ref         string
+}
+
+// MergeOptions control how a MR is merged.
+// Empty commit messages and a nil Squash leave the choice to GitLab.
+type MergeOptions struct {
+	// SHA is the expected head of the MR, the merge fails if the head differs
+	SHA                 string
+	Squash              *bool
+	RemoveSourceBranch  bool
+	MergeCommitMessage  string
+	SquashCommitMessage string
+}
+
+// acceptOptions converts the merge options into options for GitLab's accept MR API.
+func (o MergeOptions) acceptOptions() *gitlab.AcceptMergeRequestOptions {
+	opts := &gitlab.AcceptMergeRequestOptions{
+		Squash:                   o.Squash,
+		ShouldRemoveSourceBranch: gitlab.Ptr(o.RemoveSourceBranch),
+	}
+	if o.SHA != "" {
+		opts.SHA = gitlab.Ptr(o.SHA)
+	}
+	if o.MergeCommitMessage != "" {
+		opts.MergeCommitMessage = gitlab.Ptr(o.MergeCommitMessage)
+	}
+	if o.SquashCommitMessage != "" {
+		opts.SquashCommitMessage = gitlab.Ptr(o.SquashCommitMessage)
+	}
+	return opts
+}
 
 type GitlabConfig struct {
 	AccessToken string
@@ -15,11 +78,23 @@ type GitlabConfig struct {
 }
 
 type GitlabClient interface {
-	GetConfigFileForMR(mr *gitlab.MergeRequest, filePath string) (*[]byte, error)
-	ListMrsWithLabel(label string) ([]*gitlab.MergeRequest, error)
+	GetConfigLocationForMR(mr *gitlab.MergeRequest, configRef string) (ConfigLocation, error)
+	GetConfigFile(location ConfigLocation, filePath string) (*[]byte, error)
+	ListMrChangedFiles(mr *gitlab.MergeRequest) ([]string, error)
+	ListFreezePeriods(mr *gitlab.MergeRequest) ([]*gitlab.FreezePeriod, error)
+	ListMrsWithLabelPrefix(prefix string) ([]*gitlab.MergeRequest, error)
+	GetLabelAddedTime(mr *gitlab.MergeRequest, label string) (time.Time, error)
+	GetMrHeadAt(mr *gitlab.MergeRequest, at time.Time) (string, error)
+	ListMrDiffVersions(mr *gitlab.MergeRequest) ([]*gitlab.MergeRequestDiffVersion, error)
 	RefreshMr(mr *gitlab.MergeRequest) (*gitlab.MergeRequest, error)
-	MergeMr(mr *gitlab.MergeRequest) error
+	GetLastPipelineDuration(mr *gitlab.MergeRequest) (time.Duration, error)
+	GetMr(project string, iid int) (*gitlab.MergeRequest, error)
+	RebaseMr(mr *gitlab.MergeRequest) error
+	MergeMr(mr *gitlab.MergeRequest, options MergeOptions) error
+	AutoMergeMr(mr *gitlab.MergeRequest, options MergeOptions) error
+	CancelAutoMergeMr(mr *gitlab.MergeRequest) error
 	Comment(mr *gitlab.MergeRequest, title string, comment string) error
+	FindComment(mr *gitlab.MergeRequest, title string) (string, error)
 }
 
 type gitlabClientImpl struct {
@@ -44,16 +119,193 @@ func NewGitlabClient(config GitlabConfig) (GitlabClient, error) {
 	}, nil
 }
 
-func (g *gitlabClientImpl) GetConfigFileForMR(mr *gitlab.MergeRequest, filePath string) (*[]byte, error) {
-	opts := &gitlab.GetRawFileOptions{Ref: &mr.SourceBranch}
-	file, _, err := g.client.RepositoryFiles.GetRawFile(mr.ProjectID, filePath, opts)
+// GetConfigLocationForMR returns the location of the config for the given MR.
+// For MRs from forks, the source branch is located in the fork project.
+func (g *gitlabClientImpl) GetConfigLocationForMR(mr *gitlab.MergeRequest, configRef string) (ConfigLocation, error) {
+	location := ConfigLocation{
+		ProjectID:   mr.ProjectID,
+		ProjectPath: ProjectPathOfMr(mr),
+	}
+	switch configRef {
+	case "", CONFIG_REF_SOURCE:
+		location.Ref = mr.SourceBranch
+		if mr.SourceProjectID != 0 && mr.SourceProjectID != mr.ProjectID {
+			project, _, err := g.client.Projects.GetProject(mr.SourceProjectID, &gitlab.GetProjectOptions{})
+			if err != nil {
+				return ConfigLocation{}, fmt.Errorf("failed to get source project: %w", err)
+			}
+			location.ProjectID = project.ID
+			location.ProjectPath = project.PathWithNamespace
+		}
+	case CONFIG_REF_TARGET:
+		location.Ref = mr.TargetBranch
+	case CONFIG_REF_DEFAULT_BRANCH:
+		project, _, err := g.client.Projects.GetProject(mr.ProjectID, &gitlab.GetProjectOptions{})
+		if err != nil {
+			return ConfigLocation{}, fmt.Errorf("failed to get project: %w", err)
+		}
+		location.Ref = project.DefaultBranch
+		location.ProjectPath = project.PathWithNamespace
+	default:
+		return ConfigLocation{}, fmt.Errorf("unknown config ref: %s", configRef)
+	}
+	return location, nil
+}
+
+func (g *gitlabClientImpl) GetConfigFile(location ConfigLocation, filePath string) (*[]byte, error) {
+	opts := &gitlab.GetRawFileOptions{}
+	if location.Ref != "" {
+		opts.Ref = &location.Ref
+	}
+	var pid interface{} = location.ProjectID
+	if location.ProjectID == 0 {
+		pid = location.ProjectPath
+	}
+	file, _, err := g.client.RepositoryFiles.GetRawFile(pid, filePath, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch config file: %w", err)
 	}
 	return &file, nil
 }
 
-func (g *gitlabClientImpl) ListMrsWithLabel(label string) ([]*gitlab.MergeRequest, error) {
+func (g *gitlabClientImpl) ListMrChangedFiles(mr *gitlab.MergeRequest) ([]string, error) {
+	opts := &gitlab.ListMergeRequestDiffsOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 20,
+			Page:    1,
+		},
+	}
+	var allFiles []string
+
+	for {
+		diffs, resp, err := g.client.MergeRequests.ListMergeRequestDiffs(mr.ProjectID, mr.IID, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list MR diffs: %w", err)
+		}
+		for _, d := range diffs {
+			allFiles = append(allFiles, d.NewPath)
+			if d.RenamedFile {
+				allFiles = append(allFiles, d.OldPath)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return allFiles, nil
+}
+
+func (g *gitlabClientImpl) ListFreezePeriods(mr *gitlab.MergeRequest) ([]*gitlab.FreezePeriod, error) {
+	opts := &gitlab.ListFreezePeriodsOptions{
+		PerPage: 20,
+		Page:    1,
+	}
+	var allFreezes []*gitlab.FreezePeriod
+
+	for {
+		freezes, resp, err := g.client.FreezePeriods.ListFreezePeriods(mr.ProjectID, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list freeze periods: %w", err)
+		}
+		allFreezes = append(allFreezes, freezes...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return allFreezes, nil
+}
+
+// ListMrsWithLabelPrefix returns all open MRs with the given label, or a scoped label directly below it like `prefix::value`.
+// GitLab can't filter MRs by label prefix, so the matching labels are looked up in the projects the user is a member of,
+// and the MRs are listed for each of these labels.
+func (g *gitlabClientImpl) ListMrsWithLabelPrefix(prefix string) ([]*gitlab.MergeRequest, error) {
+	labels, err := g.listScopedLabels(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int]bool{}
+	var allMrs []*gitlab.MergeRequest
+	for _, label := range append([]string{prefix}, labels...) {
+		mrs, err := g.listMrsWithLabel(label)
+		if err != nil {
+			return nil, err
+		}
+		for _, mr := range mrs {
+			if !seen[mr.ID] {
+				seen[mr.ID] = true
+				allMrs = append(allMrs, mr)
+			}
+		}
+	}
+	return allMrs, nil
+}
+
+// listScopedLabels returns the names of the labels directly below the given prefix, like `prefix::value`,
+// in the projects the user is a member of and their groups.
+func (g *gitlabClientImpl) listScopedLabels(prefix string) ([]string, error) {
+	popts := &gitlab.ListProjectsOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 100,
+			Page:    1,
+		},
+		Membership:               gitlab.Ptr(true),
+		Archived:                 gitlab.Ptr(false),
+		WithMergeRequestsEnabled: gitlab.Ptr(true),
+		Simple:                   gitlab.Ptr(true),
+	}
+	names := map[string]bool{}
+
+	for {
+		projects, resp, err := g.client.Projects.ListProjects(popts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list projects: %w", err)
+		}
+		for _, p := range projects {
+			lopts := &gitlab.ListLabelsOptions{
+				ListOptions: gitlab.ListOptions{
+					PerPage: 100,
+					Page:    1,
+				},
+				IncludeAncestorGroups: gitlab.Ptr(true),
+				Search:                gitlab.Ptr(prefix + "::"),
+			}
+			for {
+				labels, lresp, err := g.client.Labels.ListLabels(p.ID, lopts)
+				if err != nil {
+					return nil, fmt.Errorf("failed to list labels of project %s: %w", p.PathWithNamespace, err)
+				}
+				for _, l := range labels {
+					scope, ok := strings.CutPrefix(l.Name, prefix+"::")
+					if ok && scope != "" && !strings.Contains(scope, "::") {
+						names[l.Name] = true
+					}
+				}
+				if lresp.NextPage == 0 {
+					break
+				}
+				lopts.Page = lresp.NextPage
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		popts.Page = resp.NextPage
+	}
+
+	labels := make([]string, 0, len(names))
+	for name := range names {
+		labels = append(labels, name)
+	}
+	slices.Sort(labels)
+	return labels, nil
+}
+
+func (g *gitlabClientImpl) listMrsWithLabel(label string) ([]*gitlab.MergeRequest, error) {
 	labels := gitlab.LabelOptions{label}
 	opts := &gitlab.ListMergeRequestsOptions{
 		ListOptions: gitlab.ListOptions{
@@ -82,8 +334,107 @@ func (g *gitlabClientImpl) ListMrsWithLabel(label string) ([]*gitlab.MergeReques
 	return allMrs, nil
 }
 
+// GetLabelAddedTime returns the time at which the label was most recently added to the MR,
+// or the zero time if there is no record of it.
+func (g *gitlabClientImpl) GetLabelAddedTime(mr *gitlab.MergeRequest, label string) (time.Time, error) {
+	opts := &gitlab.ListLabelEventsOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: 20,
+			Page:    1,
+		},
+	}
+	var added time.Time
+
+	for {
+		events, resp, err := g.client.ResourceLabelEvents.ListMergeRequestsLabelEvents(mr.ProjectID, mr.IID, opts)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to list label events: %w", err)
+		}
+		for _, e := range events {
+			if e.Action == "add" && e.Label.Name == label && e.CreatedAt != nil && e.CreatedAt.After(added) {
+				added = *e.CreatedAt
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return added, nil
+}
+
+// GetMrHeadAt returns the head SHA of the MR at the given time, based on its diff versions.
+// If the first version was created after the given time, e.g. because the label was added when creating the MR, its head is returned.
+func (g *gitlabClientImpl) GetMrHeadAt(mr *gitlab.MergeRequest, at time.Time) (string, error) {
+	opts := &gitlab.GetMergeRequestDiffVersionsOptions{
+		PerPage: 100,
+		Page:    1,
+	}
+	var head, first string
+	var headCreated, firstCreated time.Time
+
+	for {
+		versions, resp, err := g.client.MergeRequests.GetMergeRequestDiffVersions(mr.ProjectID, mr.IID, opts)
+		if err != nil {
+			return "", fmt.Errorf("failed to list diff versions: %w", err)
+		}
+		for _, v := range versions {
+			if v.CreatedAt == nil || v.HeadCommitSHA == "" {
+				continue
+			}
+			if !v.CreatedAt.After(at) && (head == "" || v.CreatedAt.After(headCreated)) {
+				head, headCreated = v.HeadCommitSHA, *v.CreatedAt
+			}
+			if first == "" || v.CreatedAt.Before(firstCreated) {
+				first, firstCreated = v.HeadCommitSHA, *v.CreatedAt
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if head != "" {
+		return head, nil
+	}
+	if first != "" {
+		return first, nil
+	}
+	return "", fmt.Errorf("MR has no diff versions")
+}
+
+// ListMrDiffVersions returns the diff versions of the MR, newest first.
+func (g *gitlabClientImpl) ListMrDiffVersions(mr *gitlab.MergeRequest) ([]*gitlab.MergeRequestDiffVersion, error) {
+	opts := &gitlab.GetMergeRequestDiffVersionsOptions{
+		PerPage: 100,
+		Page:    1,
+	}
+	versions := make([]*gitlab.MergeRequestDiffVersion, 0)
+
+	for {
+		page, resp, err := g.client.MergeRequests.GetMergeRequestDiffVersions(mr.ProjectID, mr.IID, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list diff versions: %w", err)
+		}
+		versions = append(versions, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	slices.SortStableFunc(versions, func(a, b *gitlab.MergeRequestDiffVersion) int {
+		return b.ID - a.ID
+	})
+	return versions, nil
+}
+
 func (g *gitlabClientImpl) RefreshMr(mr *gitlab.MergeRequest) (*gitlab.MergeRequest, error) {
-	opts := &gitlab.GetMergeRequestsOptions{}
+	opts := &gitlab.GetMergeRequestsOptions{
+		IncludeRebaseInProgress: gitlab.Ptr(true),
+	}
 	mr, _, err := g.client.MergeRequests.GetMergeRequest(mr.ProjectID, mr.IID, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get MR: %w", err)
@@ -92,8 +443,38 @@ func (g *gitlabClientImpl) RefreshMr(mr *gitlab.MergeRequest) (*gitlab.MergeRequ
 	return mr, nil
 }
 
-func (g *gitlabClientImpl) MergeMr(mr *gitlab.MergeRequest) error {
-	opts := &gitlab.AcceptMergeRequestOptions{ShouldRemoveSourceBranch: gitlab.Ptr(true)}
+// GetLastPipelineDuration returns the duration of the latest successful pipeline of the MR, or zero if there is none.
+func (g *gitlabClientImpl) GetLastPipelineDuration(mr *gitlab.MergeRequest) (time.Duration, error) {
+	pipelines, _, err := g.client.MergeRequests.ListMergeRequestPipelines(mr.ProjectID, mr.IID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list MR pipelines: %w", err)
+	}
+	for _, p := range pipelines {
+		if p.Status != PIPELINE_STATUS_SUCCESS {
+			continue
+		}
+		pipeline, _, err := g.client.Pipelines.GetPipeline(p.ProjectID, p.ID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get pipeline: %w", err)
+		}
+		return time.Duration(pipeline.Duration) * time.Second, nil
+	}
+	return 0, nil
+}
+
+// GetMr returns the MR with the given IID in the project, which is identified by its ID or full path.
+func (g *gitlabClientImpl) GetMr(project string, iid int) (*gitlab.MergeRequest, error) {
+	opts := &gitlab.GetMergeRequestsOptions{}
+	mr, _, err := g.client.MergeRequests.GetMergeRequest(project, iid, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MR: %w", err)
+	}
+
+	return mr, nil
+}
+
+func (g *gitlabClientImpl) MergeMr(mr *gitlab.MergeRequest, options MergeOptions) error {
+	opts := options.acceptOptions()
 	_, _, err := g.client.MergeRequests.AcceptMergeRequest(mr.ProjectID, mr.IID, opts)
 	if err != nil {
 		return fmt.Errorf("failed to merge MR: %w", err)
@@ -101,6 +482,36 @@ func (g *gitlabClientImpl) MergeMr(mr *gitlab.MergeRequest) error {
 	return nil
 }
 
+// RebaseMr starts a rebase of the MR's source branch onto its target branch.
+// The rebase is done asynchronously by GitLab, its progress is reported by RefreshMr.
+func (g *gitlabClientImpl) RebaseMr(mr *gitlab.MergeRequest) error {
+	_, err := g.client.MergeRequests.RebaseMergeRequest(mr.ProjectID, mr.IID, &gitlab.RebaseMergeRequestOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to rebase MR: %w", err)
+	}
+	return nil
+}
+
+// AutoMergeMr sets the MR to be merged by GitLab as soon as its pipeline succeeds.
+func (g *gitlabClientImpl) AutoMergeMr(mr *gitlab.MergeRequest, options MergeOptions) error {
+	opts := options.acceptOptions()
+	opts.MergeWhenPipelineSucceeds = gitlab.Ptr(true)
+	_, _, err := g.client.MergeRequests.AcceptMergeRequest(mr.ProjectID, mr.IID, opts)
+	if err != nil {
+		return fmt.Errorf("failed to set MR to auto-merge: %w", err)
+	}
+	return nil
+}
+
+// CancelAutoMergeMr cancels merging the MR once its pipeline succeeds.
+func (g *gitlabClientImpl) CancelAutoMergeMr(mr *gitlab.MergeRequest) error {
+	_, _, err := g.client.MergeRequests.CancelMergeWhenPipelineSucceeds(mr.ProjectID, mr.IID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel auto-merge of MR: %w", err)
+	}
+	return nil
+}
+
 func (g *gitlabClientImpl) Comment(mr *gitlab.MergeRequest, title string, comment string) error {
 	full_comment := fmt.Sprintf("**%s**:  %s", title, comment)
 	nopts := &gitlab.ListMergeRequestNotesOptions{}
@@ -139,6 +550,30 @@ func (g *gitlabClientImpl) Comment(mr *gitlab.MergeRequest, title string, commen
 	return nil
 }
 
+// FindComment returns the body of the newest own comment with the given title, or an empty string if there is none.
+func (g *gitlabClientImpl) FindComment(mr *gitlab.MergeRequest, title string) (string, error) {
+	nopts := &gitlab.ListMergeRequestNotesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		OrderBy:     gitlab.Ptr("created_at"),
+		Sort:        gitlab.Ptr("desc"),
+	}
+	for {
+		notes, resp, err := g.client.Notes.ListMergeRequestNotes(mr.ProjectID, mr.IID, nopts)
+		if err != nil {
+			return "", fmt.Errorf("failed to get comments on MR: %w", err)
+		}
+		for _, n := range notes {
+			if n.Author.ID == g.me.ID && title == extractTitleFromComment(n.Body) {
+				return n.Body, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return "", nil
+		}
+		nopts.Page = resp.NextPage
+	}
+}
+
 func extractTitleFromComment(comment string) string {
 	parts := strings.Split(comment, "**")
 	if len(parts) >= 2 {
@@ -147,6 +582,29 @@ func extractTitleFromComment(comment string) string {
 	return ""
 }
 
+// ProjectPathOfMr returns the full path of the MR's target project, or an empty string if unknown.
+func ProjectPathOfMr(mr *gitlab.MergeRequest) string {
+	if mr.References == nil {
+		return ""
+	}
+	path, _, _ := strings.Cut(mr.References.Full, "!")
+	return path
+}
+
 func IsMergeable(mr *gitlab.MergeRequest) bool {
 	return mr.DetailedMergeStatus == MR_MERGE_STATUS_MERGEABLE
 }
+
+// IsNotFound checks if the error was caused by a resource not existing in GitLab.
+func IsNotFound(err error) bool {
+	var errResp *gitlab.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		return errResp.Response.StatusCode == http.StatusNotFound
+	}
+	return errors.Is(err, gitlab.ErrNotFound)
+}
+
+// HasTransientMergeStatus checks if the MR is not mergeable only temporarily, e.g. because its pipeline is still running.
+func HasTransientMergeStatus(mr *gitlab.MergeRequest) bool {
+	return slices.Contains(transientMergeStatuses, mr.DetailedMergeStatus)
+}
@@ -11,7 +11,9 @@ package mock_client
 
 import (
 	reflect "reflect"
+	time "time"
 
+	client "github.com/vshn/gitlab-scheduled-merge/client"
 	gitlab "github.com/xanzy/go-gitlab"
 	gomock "go.uber.org/mock/gomock"
 )
@@ -39,6 +41,34 @@ func (m *MockGitlabClient) EXPECT() *MockGitlabClientMockRecorder {
 	return m.recorder
 }
 
+// AutoMergeMr mocks base method.
+func (m *MockGitlabClient) AutoMergeMr(mr *gitlab.MergeRequest, options client.MergeOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AutoMergeMr", mr, options)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AutoMergeMr indicates an expected call of AutoMergeMr.
+func (mr_2 *MockGitlabClientMockRecorder) AutoMergeMr(mr, options any) *gomock.Call {
+	mr_2.mock.ctrl.T.Helper()
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "AutoMergeMr", reflect.TypeOf((*MockGitlabClient)(nil).AutoMergeMr), mr, options)
+}
+
+// CancelAutoMergeMr mocks base method.
+func (m *MockGitlabClient) CancelAutoMergeMr(mr *gitlab.MergeRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelAutoMergeMr", mr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CancelAutoMergeMr indicates an expected call of CancelAutoMergeMr.
+func (mr_2 *MockGitlabClientMockRecorder) CancelAutoMergeMr(mr any) *gomock.Call {
+	mr_2.mock.ctrl.T.Helper()
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "CancelAutoMergeMr", reflect.TypeOf((*MockGitlabClient)(nil).CancelAutoMergeMr), mr)
+}
+
 // Comment mocks base method.
 func (m *MockGitlabClient) Comment(mr *gitlab.MergeRequest, title, comment string) error {
 	m.ctrl.T.Helper()
@@ -53,48 +83,197 @@ func (mr_2 *MockGitlabClientMockRecorder) Comment(mr, title, comment any) *gomoc
 	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "Comment", reflect.TypeOf((*MockGitlabClient)(nil).Comment), mr, title, comment)
 }
 
-// GetConfigFileForMR mocks base method.
-func (m *MockGitlabClient) GetConfigFileForMR(mr *gitlab.MergeRequest, filePath string) (*[]byte, error) {
+// FindComment mocks base method.
+func (m *MockGitlabClient) FindComment(mr *gitlab.MergeRequest, title string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FindComment", mr, title)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindComment indicates an expected call of FindComment.
+func (mr_2 *MockGitlabClientMockRecorder) FindComment(mr, title any) *gomock.Call {
+	mr_2.mock.ctrl.T.Helper()
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "FindComment", reflect.TypeOf((*MockGitlabClient)(nil).FindComment), mr, title)
+}
+
+// GetConfigFile mocks base method.
+func (m *MockGitlabClient) GetConfigFile(location client.ConfigLocation, filePath string) (*[]byte, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetConfigFileForMR", mr, filePath)
+	ret := m.ctrl.Call(m, "GetConfigFile", location, filePath)
 	ret0, _ := ret[0].(*[]byte)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetConfigFileForMR indicates an expected call of GetConfigFileForMR.
-func (mr_2 *MockGitlabClientMockRecorder) GetConfigFileForMR(mr, filePath any) *gomock.Call {
+// GetConfigFile indicates an expected call of GetConfigFile.
+func (mr *MockGitlabClientMockRecorder) GetConfigFile(location, filePath any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetConfigFile", reflect.TypeOf((*MockGitlabClient)(nil).GetConfigFile), location, filePath)
+}
+
+// GetConfigLocationForMR mocks base method.
+func (m *MockGitlabClient) GetConfigLocationForMR(mr *gitlab.MergeRequest, configRef string) (client.ConfigLocation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetConfigLocationForMR", mr, configRef)
+	ret0, _ := ret[0].(client.ConfigLocation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetConfigLocationForMR indicates an expected call of GetConfigLocationForMR.
+func (mr_2 *MockGitlabClientMockRecorder) GetConfigLocationForMR(mr, configRef any) *gomock.Call {
+	mr_2.mock.ctrl.T.Helper()
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "GetConfigLocationForMR", reflect.TypeOf((*MockGitlabClient)(nil).GetConfigLocationForMR), mr, configRef)
+}
+
+// GetLabelAddedTime mocks base method.
+func (m *MockGitlabClient) GetLabelAddedTime(mr *gitlab.MergeRequest, label string) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLabelAddedTime", mr, label)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLabelAddedTime indicates an expected call of GetLabelAddedTime.
+func (mr_2 *MockGitlabClientMockRecorder) GetLabelAddedTime(mr, label any) *gomock.Call {
+	mr_2.mock.ctrl.T.Helper()
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "GetLabelAddedTime", reflect.TypeOf((*MockGitlabClient)(nil).GetLabelAddedTime), mr, label)
+}
+
+// GetLastPipelineDuration mocks base method.
+func (m *MockGitlabClient) GetLastPipelineDuration(mr *gitlab.MergeRequest) (time.Duration, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLastPipelineDuration", mr)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLastPipelineDuration indicates an expected call of GetLastPipelineDuration.
+func (mr_2 *MockGitlabClientMockRecorder) GetLastPipelineDuration(mr any) *gomock.Call {
 	mr_2.mock.ctrl.T.Helper()
-	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "GetConfigFileForMR", reflect.TypeOf((*MockGitlabClient)(nil).GetConfigFileForMR), mr, filePath)
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "GetLastPipelineDuration", reflect.TypeOf((*MockGitlabClient)(nil).GetLastPipelineDuration), mr)
 }
 
-// ListMrsWithLabel mocks base method.
-func (m *MockGitlabClient) ListMrsWithLabel(label string) ([]*gitlab.MergeRequest, error) {
+// GetMr mocks base method.
+func (m *MockGitlabClient) GetMr(project string, iid int) (*gitlab.MergeRequest, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListMrsWithLabel", label)
+	ret := m.ctrl.Call(m, "GetMr", project, iid)
+	ret0, _ := ret[0].(*gitlab.MergeRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMr indicates an expected call of GetMr.
+func (mr *MockGitlabClientMockRecorder) GetMr(project, iid any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMr", reflect.TypeOf((*MockGitlabClient)(nil).GetMr), project, iid)
+}
+
+// GetMrHeadAt mocks base method.
+func (m *MockGitlabClient) GetMrHeadAt(mr *gitlab.MergeRequest, at time.Time) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMrHeadAt", mr, at)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMrHeadAt indicates an expected call of GetMrHeadAt.
+func (mr_2 *MockGitlabClientMockRecorder) GetMrHeadAt(mr, at any) *gomock.Call {
+	mr_2.mock.ctrl.T.Helper()
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "GetMrHeadAt", reflect.TypeOf((*MockGitlabClient)(nil).GetMrHeadAt), mr, at)
+}
+
+// ListFreezePeriods mocks base method.
+func (m *MockGitlabClient) ListFreezePeriods(mr *gitlab.MergeRequest) ([]*gitlab.FreezePeriod, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListFreezePeriods", mr)
+	ret0, _ := ret[0].([]*gitlab.FreezePeriod)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListFreezePeriods indicates an expected call of ListFreezePeriods.
+func (mr_2 *MockGitlabClientMockRecorder) ListFreezePeriods(mr any) *gomock.Call {
+	mr_2.mock.ctrl.T.Helper()
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "ListFreezePeriods", reflect.TypeOf((*MockGitlabClient)(nil).ListFreezePeriods), mr)
+}
+
+// ListMrChangedFiles mocks base method.
+func (m *MockGitlabClient) ListMrChangedFiles(mr *gitlab.MergeRequest) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListMrChangedFiles", mr)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListMrChangedFiles indicates an expected call of ListMrChangedFiles.
+func (mr_2 *MockGitlabClientMockRecorder) ListMrChangedFiles(mr any) *gomock.Call {
+	mr_2.mock.ctrl.T.Helper()
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "ListMrChangedFiles", reflect.TypeOf((*MockGitlabClient)(nil).ListMrChangedFiles), mr)
+}
+
+// ListMrDiffVersions mocks base method.
+func (m *MockGitlabClient) ListMrDiffVersions(mr *gitlab.MergeRequest) ([]*gitlab.MergeRequestDiffVersion, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListMrDiffVersions", mr)
+	ret0, _ := ret[0].([]*gitlab.MergeRequestDiffVersion)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListMrDiffVersions indicates an expected call of ListMrDiffVersions.
+func (mr_2 *MockGitlabClientMockRecorder) ListMrDiffVersions(mr any) *gomock.Call {
+	mr_2.mock.ctrl.T.Helper()
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "ListMrDiffVersions", reflect.TypeOf((*MockGitlabClient)(nil).ListMrDiffVersions), mr)
+}
+
+// ListMrsWithLabelPrefix mocks base method.
+func (m *MockGitlabClient) ListMrsWithLabelPrefix(prefix string) ([]*gitlab.MergeRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListMrsWithLabelPrefix", prefix)
 	ret0, _ := ret[0].([]*gitlab.MergeRequest)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// ListMrsWithLabel indicates an expected call of ListMrsWithLabel.
-func (mr *MockGitlabClientMockRecorder) ListMrsWithLabel(label any) *gomock.Call {
+// ListMrsWithLabelPrefix indicates an expected call of ListMrsWithLabelPrefix.
+func (mr *MockGitlabClientMockRecorder) ListMrsWithLabelPrefix(prefix any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListMrsWithLabel", reflect.TypeOf((*MockGitlabClient)(nil).ListMrsWithLabel), label)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListMrsWithLabelPrefix", reflect.TypeOf((*MockGitlabClient)(nil).ListMrsWithLabelPrefix), prefix)
 }
 
 // MergeMr mocks base method.
-func (m *MockGitlabClient) MergeMr(mr *gitlab.MergeRequest) error {
+func (m *MockGitlabClient) MergeMr(mr *gitlab.MergeRequest, options client.MergeOptions) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "MergeMr", mr)
+	ret := m.ctrl.Call(m, "MergeMr", mr, options)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // MergeMr indicates an expected call of MergeMr.
-func (mr_2 *MockGitlabClientMockRecorder) MergeMr(mr any) *gomock.Call {
+func (mr_2 *MockGitlabClientMockRecorder) MergeMr(mr, options any) *gomock.Call {
+	mr_2.mock.ctrl.T.Helper()
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "MergeMr", reflect.TypeOf((*MockGitlabClient)(nil).MergeMr), mr, options)
+}
+
+// RebaseMr mocks base method.
+func (m *MockGitlabClient) RebaseMr(mr *gitlab.MergeRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RebaseMr", mr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RebaseMr indicates an expected call of RebaseMr.
+func (mr_2 *MockGitlabClientMockRecorder) RebaseMr(mr any) *gomock.Call {
 	mr_2.mock.ctrl.T.Helper()
-	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "MergeMr", reflect.TypeOf((*MockGitlabClient)(nil).MergeMr), mr)
+	return mr_2.mock.ctrl.RecordCallWithMethodType(mr_2.mock, "RebaseMr", reflect.TypeOf((*MockGitlabClient)(nil).RebaseMr), mr)
 }
 
 // RefreshMr mocks base method.
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/robfig/cron/v3"
 	"github.com/spf13/cobra"
@@ -34,9 +36,17 @@ func main() {
 	gitlabBaseUrl := cmd.Flags().String("gitlab-base-url", "https://gitlab.com/api/v4", "Base URL of GitLab API to use")
 	scheduledLabel := cmd.Flags().String("scheduled-label", "scheduled", "Name of the label which indicates a MR should be scheduled")
 	configFilePath := cmd.Flags().String("config-file-path", ".merge-schedule.yml", "Path of the config file in the repo which is used to configure merge windows")
+	configRef := cmd.Flags().String("config-ref", client.CONFIG_REF_SOURCE, fmt.Sprintf("Branch from which the config file is read, one of: %s", strings.Join(client.ConfigRefs, ", ")))
+	defaultConfigProject := cmd.Flags().String("default-config-project", "", "Path of the project containing default config files for repositories without their own config, and configs which can be extended")
+	defaultConfigRef := cmd.Flags().String("default-config-ref", "", "Branch of the default config project from which config files are read, defaults to the project's default branch")
+	maxMergesPerWindow := cmd.Flags().Int("max-merges-per-window", 0, "Maximum number of MRs merged per project in a single merge window, unless configured otherwise by the window. 0 means unlimited")
 	taskSchedule := cmd.Flags().String("task-schedule", "@every 15m", "Cron schedule for how frequently to process merge requests")
 
 	cmd.Run = func(*cobra.Command, []string) {
+		if !slices.Contains(client.ConfigRefs, *configRef) {
+			log.Fatalf("Invalid config ref: %s", *configRef)
+		}
+
 		gitlabConfig := client.GitlabConfig{
 			AccessToken: *gitlabToken,
 			BaseURL:     *gitlabBaseUrl,
@@ -46,7 +56,15 @@ func main() {
 			log.Fatalf("GitLab client error: %s", err.Error())
 		}
 
-		task, err := setupCronTask(gitlabClient, *taskSchedule, *scheduledLabel, *configFilePath)
+		config := task.TaskConfig{
+			MergeRequestScheduledLabel: *scheduledLabel,
+			ConfigFilePath:             *configFilePath,
+			ConfigRef:                  *configRef,
+			DefaultConfigProject:       *defaultConfigProject,
+			DefaultConfigRef:           *defaultConfigRef,
+			MaxMergesPerWindow:         *maxMergesPerWindow,
+		}
+		task, err := setupCronTask(gitlabClient, *taskSchedule, config)
 		if err != nil {
 			log.Fatalf("Error setting up cron task: %s", err.Error())
 		}
@@ -65,16 +83,12 @@ func main() {
 func setupCronTask(
 	client client.GitlabClient,
 	crontab string,
-	scheduledLabel string,
-	configFilePath string,
+	config task.TaskConfig,
 ) (*cron.Cron, error) {
-	config := task.TaskConfig{
-		MergeRequestScheduledLabel: scheduledLabel,
-		ConfigFilePath:             configFilePath,
-	}
 	periodicTask := task.NewTask(client, config)
 
-	c := cron.New()
+	// A run can take several minutes while it waits for merges, so it's skipped if the previous one is still running
+	c := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
 	_, err := c.AddFunc(crontab, func() {
 		err := periodicTask.Run()
 		if err == nil {
@@ -0,0 +1,95 @@
+package task
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vshn/gitlab-scheduled-merge/client"
+	"github.com/xanzy/go-gitlab"
+	"go.uber.org/multierr"
+)
+
+// autoMerges keeps track of the MRs set to merge when their pipeline succeeds, so the merge can be cancelled if it would happen outside of its merge window.
+// The MRs are only kept in memory, so automatic merges set before a restart of the application aren't cancelled.
+type autoMerges struct {
+	mu  sync.Mutex
+	mrs map[string]autoMerge
+}
+
+type autoMerge struct {
+	mr  *gitlab.MergeRequest
+	end time.Time
+}
+
+func newAutoMerges() *autoMerges {
+	return &autoMerges{
+		mrs: map[string]autoMerge{},
+	}
+}
+
+func autoMergeKey(mr *gitlab.MergeRequest) string {
+	return fmt.Sprintf("%d/%d", mr.ProjectID, mr.IID)
+}
+
+// add records that the MR is set to merge when its pipeline succeeds, in a merge window ending at `end`.
+func (a *autoMerges) add(mr *gitlab.MergeRequest, end time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.mrs[autoMergeKey(mr)] = autoMerge{mr: mr, end: end}
+}
+
+// remove forgets about the automatic merge of the MR.
+func (a *autoMerges) remove(mr *gitlab.MergeRequest) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.mrs, autoMergeKey(mr))
+}
+
+// list returns the tracked automatic merges.
+func (a *autoMerges) list() []autoMerge {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	merges := make([]autoMerge, 0, len(a.mrs))
+	for _, m := range a.mrs {
+		merges = append(merges, m)
+	}
+	return merges
+}
+
+// cancelAutoMerges cancels the automatic merges set by the application whose merge window has ended, or whose MR isn't in the given scheduled MRs anymore.
+// MRs which were merged in the meantime, or whose automatic merge was cancelled by someone else, are forgotten.
+func (t Task) cancelAutoMerges(scheduled []*gitlab.MergeRequest) error {
+	isScheduled := map[string]bool{}
+	for _, mr := range scheduled {
+		isScheduled[autoMergeKey(mr)] = true
+	}
+
+	errs := make([]error, 0)
+	now := t.clock.Now()
+	for _, m := range t.autoMerges.list() {
+		reason := ""
+		if !now.Before(m.end) {
+			reason = fmt.Sprintf("the merge window ended at %s before the pipeline succeeded", m.end.Format(time.UnixDate))
+		} else if !isScheduled[autoMergeKey(m.mr)] {
+			reason = fmt.Sprintf("the label `%s` was removed", t.config.MergeRequestScheduledLabel)
+		} else {
+			continue
+		}
+
+		rmr, err := t.client.RefreshMr(m.mr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if rmr.State == client.MR_STATE_OPENED && rmr.MergeWhenPipelineSucceeds {
+			if err := t.client.CancelAutoMergeMr(rmr); err != nil {
+				errs = append(errs, t.client.Comment(m.mr, COMMENT_MERGE_FAILED, fmt.Sprintf("Error while cancelling the automatic merge, because %s.\n\n%s", reason, err.Error())))
+				continue
+			}
+			errs = append(errs, t.client.Comment(m.mr, COMMENT_MERGE_WHEN_PIPELINE_SUCCEEDS, fmt.Sprintf("The automatic merge was cancelled, because %s.", reason)))
+		}
+		t.autoMerges.remove(m.mr)
+	}
+	return multierr.Combine(errs...)
+}
@@ -0,0 +1,199 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/vshn/gitlab-scheduled-merge/client"
+	"github.com/xanzy/go-gitlab"
+	"gopkg.in/yaml.v3"
+)
+
+// maxExtendsDepth limits how many configs can be chained using `extends`, which also guards against cycles.
+const maxExtendsDepth = 10
+
+var errConfigNotFound = errors.New("config file not found")
+
+// configLoader loads the config of merge requests.
+// Files from the default config project are cached, so they are only loaded once per run.
+type configLoader struct {
+	client   client.GitlabClient
+	filePath string
+
+	defaults client.ConfigLocation
+	cache    map[string]cachedConfig
+}
+
+type cachedConfig struct {
+	config RepositoryConfig
+	err    error
+}
+
+func (t Task) newConfigLoader() *configLoader {
+	return &configLoader{
+		client:   t.client,
+		filePath: t.config.ConfigFilePath,
+		defaults: client.ConfigLocation{
+			ProjectPath: t.config.DefaultConfigProject,
+			Ref:         t.config.DefaultConfigRef,
+		},
+		cache: map[string]cachedConfig{},
+	}
+}
+
+// load returns the effective config of the given MR, read from the given location.
+// If the repository doesn't contain a config file, the closest group-level default is used instead.
+func (l *configLoader) load(mr *gitlab.MergeRequest, location client.ConfigLocation) (RepositoryConfig, error) {
+	config, err := l.loadFile(location, l.filePath, 0)
+	if !errors.Is(err, errConfigNotFound) || l.defaults.ProjectPath == "" {
+		return config, err
+	}
+
+	config, defaultErr := l.loadGroupDefault(client.ProjectPathOfMr(mr))
+	if errors.Is(defaultErr, errConfigNotFound) {
+		return RepositoryConfig{}, fmt.Errorf("%w, and no default config found in project `%s`", err, l.defaults.ProjectPath)
+	}
+	return config, defaultErr
+}
+
+// loadGroupDefault returns the default config for the given project.
+// Group-level defaults are stored in the default config project in a directory named after the group.
+// The config of the closest group is used, falling back to the instance-level default in the root directory.
+func (l *configLoader) loadGroupDefault(projectPath string) (RepositoryConfig, error) {
+	candidates := []string{}
+	for group := path.Dir(projectPath); group != "." && group != "/"; group = path.Dir(group) {
+		candidates = append(candidates, path.Join(group, l.filePath))
+	}
+	candidates = append(candidates, l.filePath)
+
+	for _, c := range candidates {
+		config, err := l.loadDefault(c, 0)
+		if errors.Is(err, errConfigNotFound) {
+			continue
+		}
+		return config, err
+	}
+	return RepositoryConfig{}, errConfigNotFound
+}
+
+// loadDefault returns the config stored at the given path in the default config project.
+func (l *configLoader) loadDefault(filePath string, depth int) (RepositoryConfig, error) {
+	if l.defaults.ProjectPath == "" {
+		return RepositoryConfig{}, fmt.Errorf("can't extend `%s`, no default config project configured", filePath)
+	}
+	if cached, ok := l.cache[filePath]; ok {
+		return cached.config, cached.err
+	}
+	config, err := l.loadFile(l.defaults, filePath, depth)
+	l.cache[filePath] = cachedConfig{config, err}
+	return config, err
+}
+
+// loadFile reads and parses the config file at the given location, and resolves the config it extends.
+func (l *configLoader) loadFile(location client.ConfigLocation, filePath string, depth int) (RepositoryConfig, error) {
+	if depth > maxExtendsDepth {
+		return RepositoryConfig{}, fmt.Errorf("too many nested extends, stopped at `%s`", filePath)
+	}
+	source := describeConfigSource(location, filePath)
+
+	file, err := l.client.GetConfigFile(location, filePath)
+	if client.IsNotFound(err) {
+		return RepositoryConfig{}, fmt.Errorf("%w: %s", errConfigNotFound, source)
+	}
+	if err != nil {
+		return RepositoryConfig{}, fmt.Errorf("failed to read config file %s: %w", source, err)
+	}
+
+	config := RepositoryConfig{}
+	err = yaml.Unmarshal(*file, &config)
+	if err != nil {
+		return RepositoryConfig{}, fmt.Errorf("failed to parse config file %s: %w", source, err)
+	}
+	if err := config.validatePipelineRequirement(); err != nil {
+		return RepositoryConfig{}, fmt.Errorf("invalid config file %s: %w", source, err)
+	}
+	if err := config.validateRebase(); err != nil {
+		return RepositoryConfig{}, fmt.Errorf("invalid config file %s: %w", source, err)
+	}
+	if err := config.MergeOptions.validate(); err != nil {
+		return RepositoryConfig{}, fmt.Errorf("invalid config file %s: %w", source, err)
+	}
+
+	err = config.loadHolidayCalendars(func(path string) (*[]byte, error) {
+		return l.client.GetConfigFile(location, path)
+	})
+	if err != nil {
+		return RepositoryConfig{}, err
+	}
+	config.sources = []string{source}
+
+	if config.Extends == "" {
+		return config, nil
+	}
+	base, err := l.loadDefault(config.Extends, depth+1)
+	if err != nil {
+		// Not wrapping the error, a missing extended config must not be mistaken for a missing config file
+		return RepositoryConfig{}, fmt.Errorf("failed to load config extended by %s: %v", source, err)
+	}
+	return base.merge(config), nil
+}
+
+// merge returns the config resulting from applying `override` on top of `c`.
+// Merge windows with the same name are replaced, all other windows and freezes are added.
+// Settings are taken from `override` if they are set there.
+func (c RepositoryConfig) merge(override RepositoryConfig) RepositoryConfig {
+	merged := RepositoryConfig{
+		MergeWindows:    append([]MergeWindow{}, c.MergeWindows...),
+		Freezes:         append(append([]Freeze{}, c.Freezes...), override.Freezes...),
+		RequirePipeline: c.RequirePipeline,
+		Rebase:          c.Rebase,
+		MergeOptions:    c.MergeOptions.merge(override.MergeOptions),
+		sources:         append(append([]string{}, override.sources...), c.sources...),
+	}
+	if override.RequirePipeline != "" {
+		merged.RequirePipeline = override.RequirePipeline
+	}
+	if override.Rebase != nil {
+		merged.Rebase = override.Rebase
+	}
+	for _, w := range override.MergeWindows {
+		i := -1
+		if w.Name != "" {
+			i = indexOfWindow(merged.MergeWindows, w.Name)
+		}
+		if i >= 0 {
+			merged.MergeWindows[i] = w
+		} else {
+			merged.MergeWindows = append(merged.MergeWindows, w)
+		}
+	}
+	return merged
+}
+
+// describeSources returns a human readable description of the files the config was merged from.
+func (c RepositoryConfig) describeSources() string {
+	return strings.Join(c.sources, ", extending ")
+}
+
+func indexOfWindow(windows []MergeWindow, name string) int {
+	for i, w := range windows {
+		if w.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// describeConfigSource returns a human readable description of where a config file is read from.
+func describeConfigSource(location client.ConfigLocation, filePath string) string {
+	desc := fmt.Sprintf("`%s`", filePath)
+	if location.Ref != "" {
+		desc = fmt.Sprintf("%s on branch `%s`", desc, location.Ref)
+	}
+	if location.ProjectPath != "" {
+		desc = fmt.Sprintf("%s of project `%s`", desc, location.ProjectPath)
+	}
+	return desc
+}
@@ -0,0 +1,77 @@
+package task
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// configChanges remembers whether the heads of MRs change the config file, so their changed files are only fetched once per head commit.
+// Like automatic merges, they are only kept in memory.
+type configChanges struct {
+	mu  sync.Mutex
+	mrs map[string]configChange
+}
+
+type configChange struct {
+	sha     string
+	changed bool
+}
+
+func newConfigChanges() *configChanges {
+	return &configChanges{
+		mrs: map[string]configChange{},
+	}
+}
+
+// get returns whether the head of the MR changes the config file, and false as second value if that isn't known yet.
+func (c *configChanges) get(mr *gitlab.MergeRequest) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	change, ok := c.mrs[autoMergeKey(mr)]
+	return change.changed, ok && mr.SHA != "" && change.sha == mr.SHA
+}
+
+// set records whether the head of the MR changes the config file.
+func (c *configChanges) set(mr *gitlab.MergeRequest, changed bool) {
+	if mr.SHA == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mrs[autoMergeKey(mr)] = configChange{sha: mr.SHA, changed: changed}
+}
+
+// prune forgets about the MRs which aren't in the given scheduled MRs anymore.
+func (c *configChanges) prune(scheduled []*gitlab.MergeRequest) {
+	keys := make([]string, 0, len(scheduled))
+	for _, mr := range scheduled {
+		keys = append(keys, autoMergeKey(mr))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.mrs {
+		if !slices.Contains(keys, key) {
+			delete(c.mrs, key)
+		}
+	}
+}
+
+// changesConfig checks if the MR changes the config file.
+// The changed files are only fetched if they aren't given and it isn't known yet for the head of the MR.
+func (t Task) changesConfig(mr *gitlab.MergeRequest, changedFiles []string) (bool, error) {
+	if changedFiles == nil {
+		if changed, ok := t.configChanges.get(mr); ok {
+			return changed, nil
+		}
+		var err error
+		changedFiles, err = t.client.ListMrChangedFiles(mr)
+		if err != nil {
+			return false, err
+		}
+	}
+	changed := slices.Contains(changedFiles, t.config.ConfigFilePath)
+	t.configChanges.set(mr, changed)
+	return changed, nil
+}
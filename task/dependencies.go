@@ -0,0 +1,80 @@
+package task
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vshn/gitlab-scheduled-merge/client"
+	"github.com/xanzy/go-gitlab"
+)
+
+var (
+	// dependsOnPattern matches `Depends-On:` lines in MR descriptions
+	dependsOnPattern = regexp.MustCompile(`(?mi)^\s*Depends-On:(.*)$`)
+	// mrReferencePattern matches MR references like `!123` and `group/project!45`
+	mrReferencePattern = regexp.MustCompile(`^([\w.\-/]*)!(\d+)$`)
+	// mrUrlPattern matches MR URLs like `https://gitlab.com/group/project/-/merge_requests/45`
+	mrUrlPattern = regexp.MustCompile(`^https?://[^/]+/(.+)/-/merge_requests/(\d+)/?$`)
+)
+
+// mrDependency references a MR which has to be merged before the MR declaring the dependency.
+type mrDependency struct {
+	// project is the full path of the MR's project, or empty for MRs in the same project
+	project string
+	iid     int
+}
+
+func (d mrDependency) String() string {
+	return fmt.Sprintf("%s!%d", d.project, d.iid)
+}
+
+// parseDependencies returns the MRs referenced on `Depends-On:` lines in the description.
+// A line can contain multiple references separated by commas or spaces.
+func parseDependencies(description string) ([]mrDependency, error) {
+	deps := []mrDependency{}
+	for _, line := range dependsOnPattern.FindAllStringSubmatch(description, -1) {
+		refs := strings.FieldsFunc(line[1], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		for _, ref := range refs {
+			m := mrReferencePattern.FindStringSubmatch(ref)
+			if m == nil {
+				m = mrUrlPattern.FindStringSubmatch(ref)
+			}
+			if m == nil {
+				return nil, fmt.Errorf("invalid merge request reference '%s', expected '!<iid>', '<group>/<project>!<iid>' or a merge request URL", ref)
+			}
+			iid, err := strconv.Atoi(m[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid merge request reference '%s': %w", ref, err)
+			}
+			deps = append(deps, mrDependency{project: m[1], iid: iid})
+		}
+	}
+	return deps, nil
+}
+
+// unmergedDependencies returns descriptions of the MR's dependencies which have not been merged yet.
+func (t Task) unmergedDependencies(mr *gitlab.MergeRequest) ([]string, error) {
+	deps, err := parseDependencies(mr.Description)
+	if err != nil {
+		return nil, err
+	}
+	unmerged := []string{}
+	for _, d := range deps {
+		project := d.project
+		if project == "" {
+			project = strconv.Itoa(mr.ProjectID)
+		}
+		dmr, err := t.client.GetMr(project, d.iid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up dependency %s: %w", d, err)
+		}
+		if dmr.State != client.MR_STATE_MERGED {
+			unmerged = append(unmerged, fmt.Sprintf("%s (%s)", d, dmr.State))
+		}
+	}
+	return unmerged, nil
+}
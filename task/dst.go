@@ -0,0 +1,77 @@
+package task
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// dstSkip skips activations at times which don't exist due to a DST change.
+	dstSkip = "skip"
+	// dstShiftForward moves activations at times which don't exist due to a DST change forward by the length of the gap.
+	dstShiftForward = "shift-forward"
+	// dstRunBoth activates at both occurrences of times repeated due to a DST change.
+	dstRunBoth = "run-both"
+	// dstFirst only activates at the first occurrence of times repeated due to a DST change.
+	dstFirst = "first"
+)
+
+// maxDstShift is larger than the shift of any DST change.
+const maxDstShift = 3 * time.Hour
+
+// DstPolicy defines how a schedule handles activations at times skipped or repeated by DST changes.
+// By default, skipped times are skipped and repeated times activate twice.
+type DstPolicy struct {
+	Nonexistent string `yaml:"nonexistent"`
+	Ambiguous   string `yaml:"ambiguous"`
+}
+
+func (p DstPolicy) validate() error {
+	switch p.Nonexistent {
+	case "", dstSkip, dstShiftForward:
+	default:
+		return fmt.Errorf("invalid dstPolicy for nonexistent times '%s', expected '%s' or '%s'", p.Nonexistent, dstSkip, dstShiftForward)
+	}
+	switch p.Ambiguous {
+	case "", dstRunBoth, dstFirst:
+	default:
+		return fmt.Errorf("invalid dstPolicy for ambiguous times '%s', expected '%s' or '%s'", p.Ambiguous, dstRunBoth, dstFirst)
+	}
+	return nil
+}
+
+// resolve returns the real times at which the schedule activates for the given wall clock time in the location.
+func (p DstPolicy) resolve(wall time.Time, location *time.Location) []time.Time {
+	times := realTimes(wall, location)
+	switch {
+	case len(times) == 0 && p.Nonexistent == dstShiftForward:
+		return []time.Time{time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), location)}
+	case len(times) > 1 && p.Ambiguous == dstFirst:
+		return times[:1]
+	}
+	return times
+}
+
+// realTimes returns all times at which the clock in the location shows the given wall clock time, in chronological order.
+// There is no such time within the gap of a DST change, and there are two within the repeated period.
+func realTimes(wall time.Time, location *time.Location) []time.Time {
+	guess := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), location)
+	times := []time.Time{}
+	// The offsets before and after a DST change around the given time
+	for _, probe := range []time.Duration{-12 * time.Hour, 12 * time.Hour} {
+		_, offset := guess.Add(probe).Zone()
+		t := wall.Add(-time.Duration(offset) * time.Second).In(location)
+		if wallClock(t).Equal(wall) && (len(times) == 0 || !times[0].Equal(t)) {
+			times = append(times, t)
+		}
+	}
+	if len(times) == 2 && times[1].Before(times[0]) {
+		times[0], times[1] = times[1], times[0]
+	}
+	return times
+}
+
+// wallClock returns the wall clock time of `t` in its location, represented in UTC.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
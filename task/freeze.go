@@ -0,0 +1,222 @@
+package task
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// Freeze is a period during which no merge requests are merged, even if a merge window is active.
+// A freeze is either a fixed date range (From/To) or a recurring period (Schedule and either Duration or End).
+type Freeze struct {
+	From     string        `yaml:"from"`
+	To       string        `yaml:"to"`
+	Location string        `yaml:"location"`
+	Schedule MergeSchedule `yaml:"schedule"`
+	Duration time.Duration `yaml:"duration"`
+	End      MergeSchedule `yaml:"end"`
+}
+
+// freezeLookbacks are the periods in which frozenUntil searches for the latest start of a freeze with an end schedule.
+// Growing the period step by step keeps the search short for frequent schedules while still finding yearly ones.
+var freezeLookbacks = []time.Duration{
+	time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	366 * 24 * time.Hour,
+}
+
+// freezeFromGitlab converts a GitLab deploy freeze period into a freeze.
+func freezeFromGitlab(fp *gitlab.FreezePeriod) Freeze {
+	return Freeze{
+		Schedule: MergeSchedule{
+			Cron:     fp.FreezeStart,
+			Location: fp.CronTimezone,
+		},
+		End: MergeSchedule{
+			Cron:     fp.FreezeEnd,
+			Location: fp.CronTimezone,
+		},
+	}
+}
+
+// dateFormats are the accepted formats for dates in the config and in not-before directives,
+// e.g. the `from` and `to` fields of a freeze or one-off merge window.
+// Times without an explicit offset are interpreted in the given location.
+var dateFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	time.DateOnly,
+}
+
+// frozenUntil returns the end of the freeze period containing `t`.
+// The second return value is false if `t` is not within this freeze.
+func (f Freeze) frozenUntil(t time.Time) (time.Time, bool, error) {
+	if f.Schedule.isEmpty() {
+		from, to, err := f.dateRange()
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		return to, !t.Before(from) && t.Before(to), nil
+	}
+	if !f.End.isEmpty() {
+		return f.frozenUntilEnd(t)
+	}
+
+	if f.Duration <= 0 {
+		return time.Time{}, false, fmt.Errorf("invalid freeze: duration must be positive, got '%s'", f.Duration)
+	}
+	sched, err := f.Schedule.parse()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid freeze: %w", err)
+	}
+	start := sched.Next(t.Add(-f.Duration))
+	if start.IsZero() || start.After(t) {
+		return time.Time{}, false, nil
+	}
+	// Later occurrences may also have started already and extend the freeze
+	for next := sched.Next(start); !next.IsZero() && !next.After(t); next = sched.Next(next) {
+		start = next
+	}
+	return start.Add(f.Duration), true, nil
+}
+
+// frozenUntilEnd returns the end of the freeze period containing `t` for freezes with an end schedule.
+// The freeze is active if the latest start before `t` is not yet followed by an end.
+func (f Freeze) frozenUntilEnd(t time.Time) (time.Time, bool, error) {
+	sched, err := f.Schedule.parse()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid freeze: %w", err)
+	}
+	endSched, err := f.End.inherit(f.Schedule).parse()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid freeze end: %w", err)
+	}
+
+	for _, lookback := range freezeLookbacks {
+		start := sched.Next(t.Add(-lookback))
+		if start.IsZero() || start.After(t) {
+			continue
+		}
+		for next := sched.Next(start); !next.IsZero() && !next.After(t); next = sched.Next(next) {
+			start = next
+		}
+		end := endSched.Next(start)
+		if end.IsZero() || !end.After(t) {
+			return time.Time{}, false, nil
+		}
+		return end, true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// nextStart returns the start of the next freeze period after `t`.
+// The second return value is false if there is no such period.
+func (f Freeze) nextStart(t time.Time) (time.Time, bool, error) {
+	if f.Schedule.isEmpty() {
+		from, _, err := f.dateRange()
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		return from, from.After(t), nil
+	}
+
+	sched, err := f.Schedule.parse()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid freeze: %w", err)
+	}
+	next := sched.Next(t)
+	return next, !next.IsZero(), nil
+}
+
+// dateRange returns the absolute start and end time of a date range freeze.
+// If `to` is a plain date, the freeze lasts until the end of that day.
+func (f Freeze) dateRange() (time.Time, time.Time, error) {
+	return parseDateRange(f.From, f.To, f.Location, "freeze")
+}
+
+// parseDateRange returns the absolute start and end time of a date range of the given kind.
+// If `to` is a plain date, the range lasts until the end of that day.
+func parseDateRange(fromValue string, toValue string, locationName string, kind string) (time.Time, time.Time, error) {
+	location := time.Local
+	if locationName != "" {
+		l, err := time.LoadLocation(locationName)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("failed to load location for %s: %w", kind, err)
+		}
+		location = l
+	}
+	from, _, err := parseDate(fromValue, location)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid %s start: %w", kind, err)
+	}
+	to, dateOnly, err := parseDate(toValue, location)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid %s end: %w", kind, err)
+	}
+	if dateOnly {
+		to = to.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%s ends before it starts: %s - %s", kind, fromValue, toValue)
+	}
+	return from, to, nil
+}
+
+// parseDate parses a date in one of the dateFormats in the given location.
+// The second return value is true if the value is a plain date without a time.
+func parseDate(s string, location *time.Location) (time.Time, bool, error) {
+	for _, format := range dateFormats {
+		t, err := time.ParseInLocation(format, s, location)
+		if err == nil {
+			return t, format == time.DateOnly, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("unknown date format: '%s'", s)
+}
+
+// activeFreezeEnd returns the time at which all freezes active at `t` have ended.
+// The second return value is false if no freeze is active at `t`.
+func activeFreezeEnd(t time.Time, freezes []Freeze) (time.Time, bool, error) {
+	end := t
+	for moved := true; moved; {
+		moved = false
+		for _, f := range freezes {
+			frozenUntil, frozen, err := f.frozenUntil(end)
+			if err != nil {
+				return time.Time{}, false, err
+			}
+			if frozen && frozenUntil.After(end) {
+				end = frozenUntil
+				moved = true
+			}
+		}
+	}
+	return end, end.After(t), nil
+}
+
+// clipToFreezes shrinks the window between `start` and `end` so that it does not overlap with any freeze.
+// The start is moved past any freeze active at that time, and the end is moved to the start of the next freeze.
+// If the whole window is frozen, the returned start is not before the returned end.
+func clipToFreezes(start time.Time, end time.Time, freezes []Freeze) (time.Time, time.Time, error) {
+	start, _, err := activeFreezeEnd(start, freezes)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !start.Before(end) {
+		return start, end, nil
+	}
+
+	for _, f := range freezes {
+		nextStart, ok, err := f.nextStart(start)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if ok && nextStart.Before(end) {
+			end = nextStart
+		}
+	}
+	return start, end, nil
+}
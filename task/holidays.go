@@ -0,0 +1,250 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HolidayCalendar references the holidays on which a schedule does not activate.
+// Holidays can either be read from an ICS file in the repository, or taken from a built-in region.
+type HolidayCalendar struct {
+	File   string `yaml:"file"`
+	Region string `yaml:"region"`
+
+	// dates contains the holidays loaded from File, formatted as time.DateOnly
+	dates map[string]bool
+}
+
+// holidayRegions are the built-in holiday regions, returning the holidays of a given year.
+var holidayRegions = map[string]func(year int) []time.Time{
+	// Public holidays observed in most Swiss cantons
+	"CH": func(year int) []time.Time {
+		easter := easterSunday(year)
+		return []time.Time{
+			civilDate(year, time.January, 1),   // Neujahr
+			civilDate(year, time.January, 2),   // Berchtoldstag
+			easter.AddDate(0, 0, -2),           // Karfreitag
+			easter.AddDate(0, 0, 1),            // Ostermontag
+			easter.AddDate(0, 0, 39),           // Auffahrt
+			easter.AddDate(0, 0, 50),           // Pfingstmontag
+			civilDate(year, time.August, 1),    // Bundesfeier
+			civilDate(year, time.December, 25), // Weihnachten
+			civilDate(year, time.December, 26), // Stephanstag
+		}
+	},
+}
+
+// isHoliday checks if the date of the given time, in the time's location, is a holiday.
+func (c HolidayCalendar) isHoliday(t time.Time) bool {
+	date := t.Format(time.DateOnly)
+	if c.dates[date] {
+		return true
+	}
+	if region, ok := holidayRegions[c.Region]; ok {
+		for _, h := range region(t.Year()) {
+			if h.Format(time.DateOnly) == date {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validate checks that the calendar can be used, i.e. that the region is known and the file has been loaded.
+func (c HolidayCalendar) validate() error {
+	if _, ok := holidayRegions[c.Region]; c.Region != "" && !ok {
+		return fmt.Errorf("unknown holiday region: %s", c.Region)
+	}
+	if c.File != "" && c.dates == nil {
+		return fmt.Errorf("holiday calendar not loaded: %s", c.File)
+	}
+	return nil
+}
+
+// loadHolidayCalendars loads the holiday calendar files of all schedules in the config.
+// Each file is only fetched once.
+func (c *RepositoryConfig) loadHolidayCalendars(fetch func(path string) (*[]byte, error)) error {
+	calendars := map[string]map[string]bool{}
+	for _, s := range c.schedules() {
+		path := s.Holidays.File
+		if path == "" {
+			continue
+		}
+		if _, ok := calendars[path]; !ok {
+			file, err := fetch(path)
+			if err != nil {
+				return fmt.Errorf("failed to fetch holiday calendar %s: %w", path, err)
+			}
+			dates, err := parseHolidayCalendar(*file)
+			if err != nil {
+				return fmt.Errorf("failed to parse holiday calendar %s: %w", path, err)
+			}
+			calendars[path] = dates
+		}
+		s.Holidays.dates = calendars[path]
+	}
+	return nil
+}
+
+// schedules returns pointers to all schedules in the config.
+func (c *RepositoryConfig) schedules() []*MergeSchedule {
+	schedules := make([]*MergeSchedule, 0, 2*len(c.MergeWindows)+2*len(c.Freezes))
+	for i := range c.MergeWindows {
+		schedules = append(schedules, &c.MergeWindows[i].Schedule, &c.MergeWindows[i].End)
+	}
+	for i := range c.Freezes {
+		schedules = append(schedules, &c.Freezes[i].Schedule, &c.Freezes[i].End)
+	}
+	return schedules
+}
+
+// maxHolidayRecurrences limits the number of occurrences of a recurring event added to a holiday calendar.
+const maxHolidayRecurrences = 1000
+
+// parseHolidayCalendar parses an iCalendar (RFC 5545) file and returns all days covered by its events.
+// Only the dates of DTSTART and DTEND are taken into account, the time of day is ignored.
+// Recurring events are expanded to at most maxHolidayRecurrences occurrences.
+func parseHolidayCalendar(ics []byte) (map[string]bool, error) {
+	dates := map[string]bool{}
+	var start, end time.Time
+	var endExclusive bool
+	var rrule string
+	var exdates []string
+	inEvent := false
+
+	for _, line := range unfoldIcsLines(ics) {
+		name, value, _ := strings.Cut(line, ":")
+		name, _, _ = strings.Cut(name, ";")
+		switch strings.ToUpper(name) {
+		case "BEGIN":
+			if strings.EqualFold(value, "VEVENT") {
+				inEvent = true
+				start, end, endExclusive = time.Time{}, time.Time{}, false
+				rrule, exdates = "", nil
+			}
+		case "DTSTART", "DTEND":
+			if !inEvent {
+				continue
+			}
+			d, dateOnly, err := parseIcsDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s '%s': %w", name, value, err)
+			}
+			if strings.EqualFold(name, "DTSTART") {
+				start = d
+			} else {
+				end = d
+				// All-day events and events ending at midnight don't cover the end date
+				endExclusive = dateOnly || value[8:] == "T000000" || value[8:] == "T000000Z"
+			}
+		case "RRULE":
+			if inEvent {
+				rrule = value
+			}
+		case "EXDATE":
+			if inEvent {
+				for _, e := range strings.Split(value, ",") {
+					exdates = append(exdates, e[:min(len(e), 8)])
+				}
+			}
+		case "END":
+			if !strings.EqualFold(value, "VEVENT") || !inEvent {
+				continue
+			}
+			inEvent = false
+			if start.IsZero() {
+				return nil, fmt.Errorf("event without DTSTART")
+			}
+			if end.IsZero() {
+				end, endExclusive = start, false
+			}
+			if endExclusive && end.After(start) {
+				end = end.AddDate(0, 0, -1)
+			}
+			days := int(end.Sub(start).Hours() / 24)
+			occurrences := []time.Time{start}
+			if rrule != "" {
+				var err error
+				occurrences, err = expandHolidayRecurrence(rrule, start, exdates)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, o := range occurrences {
+				for d := 0; d <= days; d++ {
+					dates[o.AddDate(0, 0, d).Format(time.DateOnly)] = true
+				}
+			}
+		}
+	}
+	return dates, nil
+}
+
+// expandHolidayRecurrence returns the start dates of all occurrences of a recurring event.
+// The event starts at `start`, which is a date in UTC, and recurs according to the given RRULE.
+// Excluded dates are skipped on a per-day basis.
+func expandHolidayRecurrence(rule string, start time.Time, exdates []string) ([]time.Time, error) {
+	sched, err := parseRRule(rule, start.Format("20060102"), exdates, time.UTC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recurring event: %w", err)
+	}
+	occurrences := []time.Time{}
+	for o := sched.Next(start.Add(-time.Second)); !o.IsZero() && len(occurrences) < maxHolidayRecurrences; o = sched.Next(o) {
+		occurrences = append(occurrences, o)
+	}
+	return occurrences, nil
+}
+
+// unfoldIcsLines splits an iCalendar file into its content lines, joining folded lines.
+func unfoldIcsLines(ics []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(ics))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseIcsDate parses the date part of an iCalendar DATE or DATE-TIME value.
+// The second return value is true if the value is a plain date.
+func parseIcsDate(value string) (time.Time, bool, error) {
+	if len(value) < 8 {
+		return time.Time{}, false, fmt.Errorf("value too short")
+	}
+	d, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return d, len(value) == 8, nil
+}
+
+// easterSunday returns the date of Easter Sunday in the given year, using the anonymous Gregorian algorithm.
+func easterSunday(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return civilDate(year, time.Month(month), day)
+}
+
+func civilDate(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
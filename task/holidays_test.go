@@ -0,0 +1,137 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseHolidayCalendar(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		want   []string
+	}{
+		{
+			name:   "all-day event without end",
+			events: []string{"DTSTART;VALUE=DATE:20241225"},
+			want:   []string{"2024-12-25"},
+		},
+		{
+			name:   "all-day end is exclusive",
+			events: []string{"DTSTART;VALUE=DATE:20241224", "DTEND;VALUE=DATE:20241227"},
+			want:   []string{"2024-12-24", "2024-12-25", "2024-12-26"},
+		},
+		{
+			name:   "all-day event ending on its start",
+			events: []string{"DTSTART;VALUE=DATE:20241225", "DTEND;VALUE=DATE:20241225"},
+			want:   []string{"2024-12-25"},
+		},
+		{
+			name:   "end at midnight is exclusive",
+			events: []string{"DTSTART:20241224T000000", "DTEND:20241226T000000Z"},
+			want:   []string{"2024-12-24", "2024-12-25"},
+		},
+		{
+			name:   "end during the day is inclusive",
+			events: []string{"DTSTART:20241224T120000", "DTEND:20241226T120000"},
+			want:   []string{"2024-12-24", "2024-12-25", "2024-12-26"},
+		},
+		{
+			name:   "tzid dtstart",
+			events: []string{"DTSTART;TZID=Europe/Zurich:20240801T000000", "DTEND;TZID=Europe/Zurich:20240802T000000"},
+			want:   []string{"2024-08-01"},
+		},
+		{
+			name:   "utc dtstart",
+			events: []string{"DTSTART:20240801T080000Z", "DTEND:20240801T170000Z"},
+			want:   []string{"2024-08-01"},
+		},
+		{
+			name:   "yearly recurrence",
+			events: []string{"DTSTART;VALUE=DATE:20230801", "RRULE:FREQ=YEARLY;COUNT=3"},
+			want:   []string{"2023-08-01", "2024-08-01", "2025-08-01"},
+		},
+		{
+			name:   "recurring multi-day event",
+			events: []string{"DTSTART;VALUE=DATE:20231224", "DTEND;VALUE=DATE:20231227", "RRULE:FREQ=YEARLY;UNTIL=20241231T000000Z"},
+			want:   []string{"2023-12-24", "2023-12-25", "2023-12-26", "2024-12-24", "2024-12-25", "2024-12-26"},
+		},
+		{
+			name:   "recurrence by weekday",
+			events: []string{"DTSTART;VALUE=DATE:20241101", "RRULE:FREQ=YEARLY;COUNT=2;BYMONTH=11;BYDAY=4TH"},
+			want:   []string{"2024-11-28", "2025-11-27"},
+		},
+		{
+			name: "exdate",
+			events: []string{
+				"DTSTART;VALUE=DATE:20230801",
+				"RRULE:FREQ=YEARLY;COUNT=3",
+				"EXDATE;VALUE=DATE:20240801",
+			},
+			want: []string{"2023-08-01", "2025-08-01"},
+		},
+		{
+			name: "exdate with time and multiple values",
+			events: []string{
+				"DTSTART;TZID=Europe/Zurich:20230801T000000",
+				"RRULE:FREQ=YEARLY;COUNT=4",
+				"EXDATE;TZID=Europe/Zurich:20240801T000000,20250801T000000",
+			},
+			want: []string{"2023-08-01", "2026-08-01"},
+		},
+		{
+			name: "folded lines",
+			events: []string{
+				"SUMMARY:Swiss National\r\n  Day",
+				"DTSTART;VALUE=DATE:2023\r\n 0801",
+				"RRULE:FREQ=YEARLY;\r\n\tCOUNT=2",
+			},
+			want: []string{"2023-08-01", "2024-08-01"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dates, err := parseHolidayCalendar(icsCalendar(tc.events...))
+			require.NoError(t, err)
+
+			got := []string{}
+			for d := range dates {
+				got = append(got, d)
+			}
+			require.ElementsMatch(t, tc.want, got)
+		})
+	}
+}
+
+func Test_ParseHolidayCalendar_MultipleEvents(t *testing.T) {
+	ics := icsCalendar("DTSTART;VALUE=DATE:20241225")
+	ics = append(ics, icsCalendar("DTSTART;VALUE=DATE:20241226")...)
+
+	dates, err := parseHolidayCalendar(ics)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"2024-12-25": true, "2024-12-26": true}, dates)
+}
+
+func Test_ParseHolidayCalendar_Invalid(t *testing.T) {
+	tests := map[string][]string{
+		"missing dtstart": {"SUMMARY:Holiday"},
+		"invalid dtstart": {"DTSTART:2024-12-25"},
+		"short dtstart":   {"DTSTART:2024"},
+		"invalid rrule":   {"DTSTART;VALUE=DATE:20241225", "RRULE:FREQ=SECONDLY"},
+	}
+	for name, events := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseHolidayCalendar(icsCalendar(events...))
+			require.Error(t, err)
+		})
+	}
+}
+
+// icsCalendar returns a calendar containing a single event with the given properties.
+func icsCalendar(properties ...string) []byte {
+	lines := append([]string{"BEGIN:VCALENDAR", "BEGIN:VEVENT"}, properties...)
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR", "")
+	return []byte(strings.Join(lines, "\r\n"))
+}
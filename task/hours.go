@@ -0,0 +1,131 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weeklySchedule is a cron.Schedule activating at a time of day on some days of the week.
+// Like cron schedules, it is evaluated in the location of the given time.
+type weeklySchedule struct {
+	days   [7]bool
+	hour   int
+	minute int
+}
+
+// parseWeekly parses a weekly schedule like "Fri 18:00", "Mon,Thu 06:00" or "Mon-Fri 08:00".
+func parseWeekly(expr string) (weeklySchedule, error) {
+	fields := strings.Fields(strings.ToLower(expr))
+	if len(fields) != 2 {
+		return weeklySchedule{}, fmt.Errorf("invalid weekly schedule '%s', expected '<days> <HH:MM>': wrong number of fields", expr)
+	}
+	days, err := parseWeekdayList(fields[0])
+	if err != nil {
+		return weeklySchedule{}, fmt.Errorf("invalid weekly schedule '%s', expected '<days> <HH:MM>': %w", expr, err)
+	}
+	tod, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return weeklySchedule{}, fmt.Errorf("invalid weekly schedule '%s', expected '<days> <HH:MM>': invalid time '%s'", expr, fields[1])
+	}
+	return weeklySchedule{days: days, hour: tod.Hour(), minute: tod.Minute()}, nil
+}
+
+// Next returns the next activation time after `t`.
+func (s weeklySchedule) Next(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		next := time.Date(t.Year(), t.Month(), t.Day()+i, s.hour, s.minute, 0, 0, t.Location())
+		if next.After(t) && s.days[next.Weekday()] {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// parseWeekdayList parses a comma separated list of days and day ranges like "mon,wed-fri".
+// Ranges can wrap around the end of the week, e.g. "fri-mon".
+func parseWeekdayList(list string) ([7]bool, error) {
+	days := [7]bool{}
+	for _, r := range strings.Split(list, ",") {
+		from, to, isRange := strings.Cut(r, "-")
+		if !isRange {
+			to = from
+		}
+		first, ok := weekdays[from]
+		if !ok {
+			return days, fmt.Errorf("unknown day '%s'", from)
+		}
+		last, ok := weekdays[to]
+		if !ok {
+			return days, fmt.Errorf("unknown day '%s'", to)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// hoursSchedule is a cron.Schedule activating at the start of a daily time range on some days of the week.
+// The end of each activation is computed on the wall clock of the schedule's location, so the range is kept across DST changes.
+type hoursSchedule struct {
+	weeklySchedule
+	endHour   int
+	endMinute int
+	location  *time.Location
+}
+
+// parseHours parses a time range like "Mon-Fri 08:00-17:00", "Sat,Sun 10:00-12:00" or "22:00-02:00".
+// Without days, the range applies to every day. Ranges ending before they start end on the next day.
+func parseHours(expr string, location *time.Location) (hoursSchedule, error) {
+	fields := strings.Fields(strings.ToLower(expr))
+	invalid := func(reason string) (hoursSchedule, error) {
+		return hoursSchedule{}, fmt.Errorf("invalid hours '%s', expected '[<days>] <HH:MM>-<HH:MM>': %s", expr, reason)
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		return invalid("wrong number of fields")
+	}
+
+	s := hoursSchedule{location: location}
+	if len(fields) == 1 {
+		s.days = [7]bool{true, true, true, true, true, true, true}
+	} else {
+		days, err := parseWeekdayList(fields[0])
+		if err != nil {
+			return invalid(err.Error())
+		}
+		s.days = days
+	}
+
+	from, to, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return invalid("missing time range")
+	}
+	start, err := time.Parse("15:04", from)
+	if err != nil {
+		return invalid(fmt.Sprintf("invalid time '%s'", from))
+	}
+	end, err := time.Parse("15:04", to)
+	if err != nil {
+		return invalid(fmt.Sprintf("invalid time '%s'", to))
+	}
+	if start.Equal(end) {
+		return invalid("range is empty")
+	}
+	s.hour, s.minute = start.Hour(), start.Minute()
+	s.endHour, s.endMinute = end.Hour(), end.Minute()
+	return s, nil
+}
+
+// end returns the end of the time range starting at `start`.
+func (s hoursSchedule) end(start time.Time) time.Time {
+	start = start.In(s.location)
+	day := start.Day()
+	if s.endHour*60+s.endMinute <= s.hour*60+s.minute {
+		day++
+	}
+	return time.Date(start.Year(), start.Month(), day, s.endHour, s.endMinute, 0, 0, s.location)
+}
@@ -0,0 +1,110 @@
+package task
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isoWeekMatcher checks if a time is within one of the selected iso weeks.
+type isoWeekMatcher func(t time.Time) bool
+
+// parseIsoWeek parses an iso week expression.
+// The iso week can be one of the following:
+// - "": every iso week
+// - "@even": every even iso week
+// - "@odd": every odd iso week
+// - "<N>": every iso week N
+// - "<N>-<M>": every iso week from N to M, inclusive
+// - a comma separated list of the two forms above, e.g. "1,14-16,27"
+// - "@every <N> from <YYYY>-W<WW>": every Nth iso week, starting from the given week
+func parseIsoWeek(expr string) (isoWeekMatcher, error) {
+	expr = strings.TrimSpace(expr)
+	switch expr {
+	case "":
+		return func(time.Time) bool { return true }, nil
+	case "@even":
+		return func(t time.Time) bool { return isoWeekOf(t)%2 == 0 }, nil
+	case "@odd":
+		return func(t time.Time) bool { return isoWeekOf(t)%2 == 1 }, nil
+	}
+	if strings.HasPrefix(expr, "@every") {
+		return parseIsoWeekInterval(expr)
+	}
+	if strings.HasPrefix(expr, "@") {
+		return nil, fmt.Errorf("unknown iso week: %s", expr)
+	}
+
+	weeks := map[int]bool{}
+	for _, item := range strings.Split(expr, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(item), "-")
+		first, err := parseIsoWeekNumber(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid iso week '%s': %w", expr, err)
+		}
+		last := first
+		if isRange {
+			last, err = parseIsoWeekNumber(to)
+			if err != nil {
+				return nil, fmt.Errorf("invalid iso week '%s': %w", expr, err)
+			}
+			if last < first {
+				return nil, fmt.Errorf("invalid iso week '%s': range %s ends before it starts", expr, item)
+			}
+		}
+		for w := first; w <= last; w++ {
+			weeks[w] = true
+		}
+	}
+	return func(t time.Time) bool { return weeks[isoWeekOf(t)] }, nil
+}
+
+// parseIsoWeekInterval parses an expression of the form "@every <N> from <YYYY>-W<WW>".
+func parseIsoWeekInterval(expr string) (isoWeekMatcher, error) {
+	var interval, year, week int
+	_, err := fmt.Sscanf(expr, "@every %d from %d-W%d", &interval, &year, &week)
+	if err != nil {
+		return nil, fmt.Errorf("invalid iso week '%s', expected '@every <N> from <YYYY>-W<WW>': %w", expr, err)
+	}
+	if interval < 1 {
+		return nil, fmt.Errorf("invalid iso week '%s': interval must be at least 1", expr)
+	}
+	if week < 1 || week > 53 {
+		return nil, fmt.Errorf("invalid iso week '%s': week must be between 1 and 53", expr)
+	}
+	anchor := isoWeekStart(year, week)
+	return func(t time.Time) bool {
+		monday := mondayOf(t)
+		weeks := int(monday.Sub(anchor).Hours()) / (7 * 24)
+		return ((weeks%interval)+interval)%interval == 0
+	}, nil
+}
+
+func parseIsoWeekNumber(s string) (int, error) {
+	w, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a number", s)
+	}
+	if w < 1 || w > 53 {
+		return 0, fmt.Errorf("week %d is not between 1 and 53", w)
+	}
+	return w, nil
+}
+
+func isoWeekOf(t time.Time) int {
+	_, w := t.ISOWeek()
+	return w
+}
+
+// isoWeekStart returns the date of the monday of the given iso week, in UTC.
+func isoWeekStart(year int, week int) time.Time {
+	// January 4th is always in the first iso week
+	return mondayOf(civilDate(year, time.January, 4)).AddDate(0, 0, (week-1)*7)
+}
+
+// mondayOf returns the date of the monday of the week containing `t`, in UTC.
+func mondayOf(t time.Time) time.Time {
+	date := civilDate(t.Year(), t.Month(), t.Day())
+	return date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+}
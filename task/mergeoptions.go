@@ -0,0 +1,130 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/vshn/gitlab-scheduled-merge/client"
+	"github.com/xanzy/go-gitlab"
+)
+
+// MergeOptions control how scheduled MRs are merged.
+// The commit messages are Go templates, rendered with commitMessageData.
+type MergeOptions struct {
+	Squash              *bool  `yaml:"squash"`
+	RemoveSourceBranch  *bool  `yaml:"removeSourceBranch"`
+	MergeCommitMessage  string `yaml:"mergeCommitMessage"`
+	SquashCommitMessage string `yaml:"squashCommitMessage"`
+}
+
+// commitMessageData is the data available in commit message templates.
+type commitMessageData struct {
+	Title        string
+	Description  string
+	IID          int
+	Reference    string
+	Author       string
+	SourceBranch string
+	TargetBranch string
+	// Window is the name of the merge window the MR is merged in, or its schedule if the window has no name
+	Window string
+	// ScheduledAt is the time the MR was scheduled for merging
+	ScheduledAt time.Time
+}
+
+// validate checks that the commit message templates can be parsed.
+func (o MergeOptions) validate() error {
+	if _, err := parseCommitMessage("mergeCommitMessage", o.MergeCommitMessage); err != nil {
+		return err
+	}
+	if _, err := parseCommitMessage("squashCommitMessage", o.SquashCommitMessage); err != nil {
+		return err
+	}
+	return nil
+}
+
+// merge returns the options resulting from applying `override` on top of `o`.
+func (o MergeOptions) merge(override MergeOptions) MergeOptions {
+	if override.Squash != nil {
+		o.Squash = override.Squash
+	}
+	if override.RemoveSourceBranch != nil {
+		o.RemoveSourceBranch = override.RemoveSourceBranch
+	}
+	if override.MergeCommitMessage != "" {
+		o.MergeCommitMessage = override.MergeCommitMessage
+	}
+	if override.SquashCommitMessage != "" {
+		o.SquashCommitMessage = override.SquashCommitMessage
+	}
+	return o
+}
+
+// forMR returns the client merge options for merging the MR in the given window occurrences.
+// The source branch is removed unless configured otherwise.
+func (o MergeOptions) forMR(mr *gitlab.MergeRequest, occurrences []windowOccurrence, scheduledAt time.Time) (client.MergeOptions, error) {
+	windows := make([]string, 0, len(occurrences))
+	for _, occ := range occurrences {
+		name := occ.window.Name
+		if name == "" {
+			name = occ.window.expression()
+		}
+		windows = append(windows, name)
+	}
+	data := commitMessageData{
+		Title:        mr.Title,
+		Description:  mr.Description,
+		IID:          mr.IID,
+		SourceBranch: mr.SourceBranch,
+		TargetBranch: mr.TargetBranch,
+		Window:       strings.Join(windows, ", "),
+		ScheduledAt:  scheduledAt,
+	}
+	if mr.References != nil {
+		data.Reference = mr.References.Full
+	}
+	if mr.Author != nil {
+		data.Author = mr.Author.Username
+	}
+
+	opts := client.MergeOptions{
+		Squash:             o.Squash,
+		RemoveSourceBranch: o.RemoveSourceBranch == nil || *o.RemoveSourceBranch,
+	}
+	var err error
+	opts.MergeCommitMessage, err = renderCommitMessage("mergeCommitMessage", o.MergeCommitMessage, data)
+	if err != nil {
+		return client.MergeOptions{}, err
+	}
+	opts.SquashCommitMessage, err = renderCommitMessage("squashCommitMessage", o.SquashCommitMessage, data)
+	if err != nil {
+		return client.MergeOptions{}, err
+	}
+	return opts, nil
+}
+
+func parseCommitMessage(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// renderCommitMessage renders the commit message template. An empty template results in an empty message.
+func renderCommitMessage(name string, text string, data commitMessageData) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := parseCommitMessage(name, text)
+	if err != nil {
+		return "", err
+	}
+	var msg strings.Builder
+	if err := tmpl.Execute(&msg, data); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", name, err)
+	}
+	return msg.String(), nil
+}
@@ -0,0 +1,116 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// monthlyOrdinals maps the ordinals of monthly schedules to the occurrence within the month, -1 being the last one.
+var monthlyOrdinals = map[string]int{
+	"1st": 1, "first": 1,
+	"2nd": 2, "second": 2,
+	"3rd": 3, "third": 3,
+	"4th": 4, "fourth": 4,
+	"5th": 5, "fifth": 5,
+	"last": -1,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// monthlySchedule is a cron.Schedule activating once a month, on the Nth or last weekday or business day of the month.
+type monthlySchedule struct {
+	// ordinal is the occurrence of the day within the month, -1 being the last one
+	ordinal int
+	// weekday is the day of the week, unless businessDay is set
+	weekday     time.Weekday
+	businessDay bool
+	holidays    HolidayCalendar
+	hour        int
+	minute      int
+	location    *time.Location
+}
+
+// parseMonthly parses a monthly schedule of the form "<ordinal> <day> <HH:MM>", e.g. "2nd tue 09:00", "last friday 18:00" or "last business day 17:00".
+// Business days are Monday to Friday, excluding the given holidays.
+func parseMonthly(expr string, location *time.Location, holidays HolidayCalendar) (monthlySchedule, error) {
+	fields := strings.Fields(strings.ToLower(expr))
+	invalid := func(reason string) (monthlySchedule, error) {
+		return monthlySchedule{}, fmt.Errorf("invalid monthly schedule '%s', expected '<ordinal> <day> <HH:MM>': %s", expr, reason)
+	}
+	if len(fields) == 4 && fields[1] == "business" && fields[2] == "day" {
+		fields = []string{fields[0], "business day", fields[3]}
+	}
+	if len(fields) != 3 {
+		return invalid("wrong number of fields")
+	}
+
+	s := monthlySchedule{holidays: holidays, location: location}
+	ordinal, ok := monthlyOrdinals[fields[0]]
+	if !ok {
+		return invalid(fmt.Sprintf("unknown ordinal '%s'", fields[0]))
+	}
+	s.ordinal = ordinal
+	if fields[1] == "business day" {
+		s.businessDay = true
+	} else if s.weekday, ok = weekdays[fields[1]]; !ok {
+		return invalid(fmt.Sprintf("unknown day '%s'", fields[1]))
+	}
+	tod, err := time.Parse("15:04", fields[2])
+	if err != nil {
+		return invalid(fmt.Sprintf("invalid time '%s'", fields[2]))
+	}
+	s.hour, s.minute = tod.Hour(), tod.Minute()
+	return s, nil
+}
+
+// Next returns the next activation time after `t`, or the zero time if none can be found.
+func (s monthlySchedule) Next(t time.Time) time.Time {
+	t = t.In(s.location)
+	year, month := t.Year(), t.Month()
+	// Every ordinal occurs at least once within a year
+	for i := 0; i < 13; i++ {
+		day, ok := s.dayInMonth(year, month+time.Month(i))
+		if ok {
+			next := time.Date(day.Year(), day.Month(), day.Day(), s.hour, s.minute, 0, 0, s.location)
+			if next.After(t) {
+				return next
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// dayInMonth returns the matching day of the given month, if there is one.
+func (s monthlySchedule) dayInMonth(year int, month time.Month) (time.Time, bool) {
+	first := time.Date(year, month, 1, 12, 0, 0, 0, s.location)
+	days := []time.Time{}
+	for d := first; d.Month() == first.Month(); d = d.AddDate(0, 0, 1) {
+		if s.matchesDay(d) {
+			days = append(days, d)
+		}
+	}
+	i := s.ordinal - 1
+	if s.ordinal < 0 {
+		i = len(days) + s.ordinal
+	}
+	if i < 0 || i >= len(days) {
+		return time.Time{}, false
+	}
+	return days[i], true
+}
+
+func (s monthlySchedule) matchesDay(d time.Time) bool {
+	if !s.businessDay {
+		return d.Weekday() == s.weekday
+	}
+	return d.Weekday() != time.Saturday && d.Weekday() != time.Sunday && !s.holidays.isHoliday(d)
+}
@@ -0,0 +1,42 @@
+package task
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// notBeforeLabelInfix separates the scheduled label from the time in not-before labels, e.g. `scheduled::not-before::2024-07-01`.
+const notBeforeLabelInfix = "::not-before::"
+
+// notBeforePattern matches `Not-Before:` lines in MR descriptions
+var notBeforePattern = regexp.MustCompile(`(?mi)^\s*Not-Before:(.*)$`)
+
+// notBefore returns the earliest time at which the MR may be merged, as requested by a `Not-Before:` line in the description or a not-before label.
+// Dates and times without an explicit offset are interpreted in the given location, usually the one of the MR's merge windows.
+// If there are multiple directives, the latest time applies. The zero time is returned if there is none.
+func (t Task) notBefore(mr *gitlab.MergeRequest, location *time.Location) (time.Time, error) {
+	values := []string{}
+	for _, line := range notBeforePattern.FindAllStringSubmatch(mr.Description, -1) {
+		values = append(values, strings.TrimSpace(line[1]))
+	}
+	prefix := t.config.MergeRequestScheduledLabel + notBeforeLabelInfix
+	for _, l := range mr.Labels {
+		if v, ok := strings.CutPrefix(l, prefix); ok {
+			values = append(values, v)
+		}
+	}
+
+	var notBefore time.Time
+	for _, v := range values {
+		nb, _, err := parseDate(v, location)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid not-before time: %w", err)
+		}
+		notBefore = maxTime(notBefore, nb)
+	}
+	return notBefore, nil
+}
@@ -0,0 +1,119 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// scheduledCommitMarker matches the marker in the scheduled commit comment, recording the head SHA of the MR and the time it was scheduled.
+// The marker ends with `rebase-pending` if the MR was still being rebased by the application when the SHA was recorded.
+var scheduledCommitMarker = regexp.MustCompile(`<!-- scheduled-sha: ([0-9a-f]+) scheduled-at: (\S+)( rebase-pending)? -->`)
+
+// errRebaseInProgress is returned if the scheduled commit can't be determined yet, because the MR is still being rebased by the application.
+var errRebaseInProgress = errors.New("the MR is still being rebased")
+
+// pinnedSHA returns the head SHA of the MR at the time it was scheduled.
+// The SHA is recorded in a comment the first time the MR is processed after being labeled, so re-adding the label pins the head at that time.
+// An empty string is returned if the head of the MR is unknown.
+// An error is returned if the time the MR was scheduled is unknown, since its head at that time can't be determined.
+func (t Task) pinnedSHA(entry queueEntry) (string, error) {
+	mr := entry.mr
+	if mr.SHA == "" {
+		return "", nil
+	}
+	if entry.scheduledAtErr != nil {
+		return "", entry.scheduledAtErr
+	}
+	body, err := t.client.FindComment(mr, COMMENT_SCHEDULED_COMMIT)
+	if err != nil {
+		return "", err
+	}
+	if m := scheduledCommitMarker.FindStringSubmatch(body); m != nil && m[2] == formatMarkerTime(entry.scheduledAt) {
+		if m[3] == "" {
+			return m[1], nil
+		}
+		rmr, err := t.client.RefreshMr(mr)
+		if err != nil {
+			return "", err
+		}
+		if rmr.RebaseInProgress {
+			return "", errRebaseInProgress
+		}
+		return t.repinRebased(rmr, m[1], entry.scheduledAt)
+	}
+	sha, err := t.client.GetMrHeadAt(mr, entry.scheduledAt)
+	if err != nil {
+		return "", err
+	}
+	return sha, t.pinSHA(mr, sha, entry.scheduledAt)
+}
+
+// pinSHA records the given SHA as the head of the MR scheduled at `scheduledAt`.
+func (t Task) pinSHA(mr *gitlab.MergeRequest, sha string, scheduledAt time.Time) error {
+	label, _ := t.scheduledLabelOf(mr)
+	return t.client.Comment(mr, COMMENT_SCHEDULED_COMMIT, fmt.Sprintf(
+		"This MR is scheduled at commit %s. Commits pushed after scheduling are not merged automatically, remove and re-add the label `%s` to schedule them.\n\n<!-- scheduled-sha: %s scheduled-at: %s -->",
+		sha,
+		label,
+		sha,
+		formatMarkerTime(scheduledAt),
+	))
+}
+
+// pinPendingRebase records that the MR scheduled at commit `sha` is being rebased by the application, so the rebased head is pinned once the rebase is done.
+func (t Task) pinPendingRebase(mr *gitlab.MergeRequest, sha string, scheduledAt time.Time) error {
+	return t.client.Comment(mr, COMMENT_SCHEDULED_COMMIT, fmt.Sprintf(
+		"This MR is scheduled at commit %s and is being rebased onto its target branch. The rebased commit will be recorded once the rebase is done.\n\n<!-- scheduled-sha: %s scheduled-at: %s rebase-pending -->",
+		sha,
+		sha,
+		formatMarkerTime(scheduledAt),
+	))
+}
+
+// repinRebased pins the head of the MR once a rebase by the application, started at the pinned commit, is done.
+// If the rebase didn't change the head, the pinned commit is recorded again, so the rebase isn't pending anymore.
+// The new head is only pinned if it is the result of the rebase, i.e. its diff version directly follows the one of the pinned commit, is the newest one, and has a different merge base.
+// Otherwise the pinned commit is kept, so the MR isn't merged until the label is added again.
+// errRebaseInProgress is returned if GitLab hasn't created the diff version of the new head yet, and a rebaseFailedError if GitLab reports that the rebase failed.
+func (t Task) repinRebased(rmr *gitlab.MergeRequest, pinned string, scheduledAt time.Time) (string, error) {
+	if rmr.SHA == pinned || rmr.SHA == "" {
+		if err := t.pinSHA(rmr, pinned, scheduledAt); err != nil {
+			return "", err
+		}
+		if rmr.MergeError != "" {
+			return "", rebaseFailedError{targetBranch: rmr.TargetBranch, mergeError: rmr.MergeError}
+		}
+		return pinned, nil
+	}
+
+	versions, err := t.client.ListMrDiffVersions(rmr)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) == 0 || versions[0].HeadCommitSHA != rmr.SHA {
+		return "", errRebaseInProgress
+	}
+	if len(versions) >= 2 && versions[1].HeadCommitSHA == pinned && versions[0].BaseCommitSHA != versions[1].BaseCommitSHA {
+		return rmr.SHA, t.pinSHA(rmr, rmr.SHA, scheduledAt)
+	}
+	return pinned, t.pinSHA(rmr, pinned, scheduledAt)
+}
+
+// headMovedNote explains that the MR isn't merged because its head differs from the pinned SHA.
+func (t Task) headMovedNote(mr *gitlab.MergeRequest, pinned string) string {
+	label, _ := t.scheduledLabelOf(mr)
+	return fmt.Sprintf(
+		"New commits were pushed after this MR was scheduled at commit %s, its head is now at commit %s. Remove and re-add the label `%s` to schedule the new commits.",
+		pinned,
+		mr.SHA,
+		label,
+	)
+}
+
+func formatMarkerTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,46 @@
+package task
+
+import (
+	"fmt"
+
+	"github.com/vshn/gitlab-scheduled-merge/client"
+	"github.com/xanzy/go-gitlab"
+)
+
+// Pipeline requirements which can be configured with `requirePipeline`.
+const (
+	requirePipelineNone             = "none"
+	requirePipelineSuccess          = "success"
+	requirePipelineSuccessOrSkipped = "success-or-skipped"
+)
+
+// validatePipelineRequirement checks that the configured pipeline requirement is known.
+func (c RepositoryConfig) validatePipelineRequirement() error {
+	switch c.RequirePipeline {
+	case "", requirePipelineNone, requirePipelineSuccess, requirePipelineSuccessOrSkipped:
+		return nil
+	}
+	return fmt.Errorf("invalid requirePipeline '%s', expected '%s', '%s' or '%s'", c.RequirePipeline, requirePipelineSuccess, requirePipelineSuccessOrSkipped, requirePipelineNone)
+}
+
+// blockingPipeline returns the reason why the MR's head pipeline blocks the merge according to the given requirement,
+// or an empty string if the MR can be merged. The MR must have been fetched individually, MR lists don't include the head pipeline.
+func blockingPipeline(mr *gitlab.MergeRequest, requirement string) string {
+	if requirement == "" || requirement == requirePipelineNone {
+		return ""
+	}
+	required := "a successful pipeline"
+	if requirement == requirePipelineSuccessOrSkipped {
+		required = "a successful or skipped pipeline"
+	}
+
+	pipeline := mr.HeadPipeline
+	if pipeline == nil {
+		return fmt.Sprintf("MR has no pipeline for its head commit, but the config requires %s.", required)
+	}
+	if pipeline.Status == client.PIPELINE_STATUS_SUCCESS ||
+		(pipeline.Status == client.PIPELINE_STATUS_SKIPPED && requirement == requirePipelineSuccessOrSkipped) {
+		return ""
+	}
+	return fmt.Sprintf("The head pipeline [#%d](%s) has status `%s`, but the config requires %s.", pipeline.ID, pipeline.WebURL, pipeline.Status, required)
+}
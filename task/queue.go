@@ -0,0 +1,111 @@
+package task
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+	"go.uber.org/multierr"
+)
+
+// priorityLabelInfix separates the scheduled label from the priority in priority labels, e.g. `scheduled::priority::high`.
+const priorityLabelInfix = "::priority::"
+
+// priorities maps the priorities which can be set using priority labels to their rank in the merge queue.
+var priorities = map[string]int{
+	"high":   0,
+	"normal": 1,
+	"low":    2,
+}
+
+const defaultPriority = "normal"
+
+// queueEntry is a scheduled MR along with the information determining its position in the merge queue.
+type queueEntry struct {
+	mr          *gitlab.MergeRequest
+	priority    string
+	scheduledAt time.Time
+	// scheduledAtErr is set if the time the scheduled label was added can't be determined, in which case scheduledAt is the creation time of the MR
+	scheduledAtErr error
+}
+
+// queueMRs orders the MRs in which they are processed: by priority, then by the time the scheduled label was added, then by IID.
+// If the time the label was added can't be determined, the creation time of the MR is used instead, and an error is returned along with the queue.
+func (t Task) queueMRs(mrs []*gitlab.MergeRequest) ([]queueEntry, error) {
+	errs := make([]error, 0)
+	queue := make([]queueEntry, 0, len(mrs))
+	for _, mr := range mrs {
+		label, _ := t.scheduledLabelOf(mr)
+		scheduledAt, err := t.client.GetLabelAddedTime(mr, label)
+		if err != nil {
+			err = fmt.Errorf("failed to determine when MR !%d was scheduled: %w", mr.IID, err)
+			errs = append(errs, err)
+		} else if scheduledAt.IsZero() {
+			err = fmt.Errorf("failed to determine when MR !%d was scheduled: label `%s` not found in the label events", mr.IID, label)
+		}
+		if scheduledAt.IsZero() && mr.CreatedAt != nil {
+			scheduledAt = *mr.CreatedAt
+		}
+		queue = append(queue, queueEntry{
+			mr:             mr,
+			priority:       t.priorityOf(mr),
+			scheduledAt:    scheduledAt,
+			scheduledAtErr: err,
+		})
+	}
+
+	sort.SliceStable(queue, func(i, j int) bool {
+		a, b := queue[i], queue[j]
+		if priorities[a.priority] != priorities[b.priority] {
+			return priorities[a.priority] < priorities[b.priority]
+		}
+		if !a.scheduledAt.Equal(b.scheduledAt) {
+			return a.scheduledAt.Before(b.scheduledAt)
+		}
+		return a.mr.IID < b.mr.IID
+	})
+	return queue, multierr.Combine(errs...)
+}
+
+// priorityOf returns the priority of the MR set by a priority label, or the default priority.
+// If there are multiple priority labels, the highest priority wins.
+func (t Task) priorityOf(mr *gitlab.MergeRequest) string {
+	priority := defaultPriority
+	prefix := t.config.MergeRequestScheduledLabel + priorityLabelInfix
+	for _, l := range mr.Labels {
+		p, ok := strings.CutPrefix(l, prefix)
+		if _, known := priorities[p]; ok && known && priorities[p] < priorities[priority] {
+			priority = p
+		}
+	}
+	return priority
+}
+
+// scheduledLabelOf returns the label with which the MR was scheduled, either the scheduled label itself or a window label like `scheduled::weekend`.
+// The second return value is false if the MR has neither, e.g. if it only has a priority label.
+func (t Task) scheduledLabelOf(mr *gitlab.MergeRequest) (string, bool) {
+	if slices.Contains(mr.Labels, t.config.MergeRequestScheduledLabel) {
+		return t.config.MergeRequestScheduledLabel, true
+	}
+	if names := t.selectedWindowNames(mr); len(names) > 0 {
+		return t.config.MergeRequestScheduledLabel + "::" + names[0], true
+	}
+	return "", false
+}
+
+// selectedWindowNames returns the names of the merge windows selected by window labels like `scheduled::weekend`.
+// Labels with further scopes, like priority labels, don't select windows.
+func (t Task) selectedWindowNames(mr *gitlab.MergeRequest) []string {
+	names := []string{}
+	prefix := t.config.MergeRequestScheduledLabel + "::"
+	for _, l := range mr.Labels {
+		name, ok := strings.CutPrefix(l, prefix)
+		if ok && name != "" && !strings.Contains(name, "::") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,83 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// mergeQuota keeps track of the MRs merged in each window occurrence, to limit the number of merges per occurrence.
+// The merged MRs are only kept in memory, so the quota starts over if the application is restarted.
+type mergeQuota struct {
+	mu     sync.Mutex
+	merged map[string]quotaUsage
+}
+
+type quotaUsage struct {
+	mrs []int
+	end time.Time
+}
+
+func newMergeQuota() *mergeQuota {
+	return &mergeQuota{
+		merged: map[string]quotaUsage{},
+	}
+}
+
+// quotaKey identifies a window occurrence in a project.
+func quotaKey(projectID int, o windowOccurrence) string {
+	return fmt.Sprintf("%d/%s/%s/%d", projectID, o.window.Name, o.window.expression(), o.scheduled.Unix())
+}
+
+// occurrencesKey identifies the combination of window occurrences in which an MR of the project is merged.
+func occurrencesKey(projectID int, occurrences []windowOccurrence) string {
+	keys := make([]string, 0, len(occurrences))
+	for _, o := range occurrences {
+		keys = append(keys, quotaKey(projectID, o))
+	}
+	return strings.Join(keys, "+")
+}
+
+// exhaustedOccurrence returns the first of the given occurrences in which no more MRs of the project can be merged, or nil if there is none.
+// The limit of a window is its MaxMerges, or the given default limit if not set. A limit of 0 means unlimited merges.
+func (q *mergeQuota) exhaustedOccurrence(projectID int, occurrences []windowOccurrence, defaultLimit int) *windowOccurrence {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, o := range occurrences {
+		limit := o.window.MaxMerges
+		if limit == 0 {
+			limit = defaultLimit
+		}
+		if limit > 0 && len(q.merged[quotaKey(projectID, o)].mrs) >= limit {
+			return &occurrences[i]
+		}
+	}
+	return nil
+}
+
+// record adds the MR to the merged MRs of the given occurrences.
+func (q *mergeQuota) record(mr *gitlab.MergeRequest, occurrences []windowOccurrence) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, o := range occurrences {
+		key := quotaKey(mr.ProjectID, o)
+		usage := q.merged[key]
+		usage.mrs = append(usage.mrs, mr.IID)
+		usage.end = o.end
+		q.merged[key] = usage
+	}
+}
+
+// prune forgets about the merges of window occurrences which have ended.
+func (q *mergeQuota) prune(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for key, usage := range q.merged {
+		if usage.end.Before(now) {
+			delete(q.merged, key)
+		}
+	}
+}
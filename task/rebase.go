@@ -0,0 +1,66 @@
+package task
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vshn/gitlab-scheduled-merge/client"
+	"github.com/xanzy/go-gitlab"
+)
+
+// RebaseConfig enables rebasing scheduled MRs onto their target branch before they are merged.
+// MRs are rebased starting `LeadTime` before their merge window, so the resulting pipeline can finish in time.
+type RebaseConfig struct {
+	LeadTime time.Duration `yaml:"leadTime"`
+}
+
+// validateRebase checks that the rebase config is valid.
+func (c RepositoryConfig) validateRebase() error {
+	if c.Rebase != nil && c.Rebase.LeadTime < 0 {
+		return fmt.Errorf("invalid rebase: leadTime must not be negative, got '%s'", c.Rebase.LeadTime)
+	}
+	return nil
+}
+
+// rebaseStartsAt returns the time from which MRs are rebased before a merge window starting at `start`.
+// The second return value is false if rebasing is disabled.
+func (c RepositoryConfig) rebaseStartsAt(start time.Time) (time.Time, bool) {
+	if c.Rebase == nil {
+		return time.Time{}, false
+	}
+	return start.Add(-c.Rebase.LeadTime), true
+}
+
+// rebaseMR starts rebasing the MR if it is behind its target branch.
+// GitLab rebases asynchronously, so the rebase isn't waited for. If the head of the MR is pinned at `sha`, the rebase is recorded as pending, and a later run pins the rebased head once it is done.
+// It returns whether the MR is being rebased, and a description of the problem if it can't be rebased automatically.
+func (t Task) rebaseMR(mr *gitlab.MergeRequest, sha string, scheduledAt time.Time) (bool, string, error) {
+	if mr.RebaseInProgress {
+		return true, "", nil
+	}
+	if mr.HasConflicts || mr.DetailedMergeStatus == client.MR_MERGE_STATUS_CONFLICT {
+		return false, fmt.Sprintf("The MR has conflicts with the target branch `%s` and can't be rebased automatically. Please resolve the conflicts, the MR stays scheduled.", mr.TargetBranch), nil
+	}
+	if mr.DetailedMergeStatus != client.MR_MERGE_STATUS_NEED_REBASE {
+		return false, "", nil
+	}
+	if err := t.client.RebaseMr(mr); err != nil {
+		return false, "", err
+	}
+	if sha != "" {
+		if err := t.pinPendingRebase(mr, sha, scheduledAt); err != nil {
+			return true, "", err
+		}
+	}
+	return true, "", nil
+}
+
+// rebaseFailedError is returned if a rebase started by the application failed.
+type rebaseFailedError struct {
+	targetBranch string
+	mergeError   string
+}
+
+func (e rebaseFailedError) Error() string {
+	return fmt.Sprintf("The rebase onto the target branch `%s` failed: %s\n\nPlease rebase the MR manually, it stays scheduled.", e.targetBranch, e.mergeError)
+}
@@ -0,0 +1,123 @@
+package task
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vshn/gitlab-scheduled-merge/client"
+	"github.com/xanzy/go-gitlab"
+	"go.uber.org/multierr"
+)
+
+// Retries of merges which were blocked by a transient merge status, e.g. a running pipeline.
+// The backoff doubles after every attempt, so the retries take about 8 minutes in total.
+// Runs of the task don't overlap, so a run is skipped if it's scheduled while the retries of the previous one are still going on.
+const (
+	retryInitialBackoff = 15 * time.Second
+	retryMaxAttempts    = 5
+)
+
+// pendingMerge is a merge of an MR in an active merge window.
+type pendingMerge struct {
+	mr              *gitlab.MergeRequest
+	occurrences     []windowOccurrence
+	end             time.Time
+	requirePipeline string
+	rebase          bool
+	mergeOptions    MergeOptions
+	// scheduledAt is the time the MR was scheduled for merging
+	scheduledAt time.Time
+	// sha is the pinned head of the MR, or empty if unknown
+	sha string
+	// rebasePending is set if the MR is being rebased by the application, so its head is pinned again once the rebase is done
+	rebasePending bool
+}
+
+// waitForMergeable handles an MR which is not mergeable only temporarily.
+// If its pipeline is expected to finish before the merge window ends, GitLab is asked to merge it once the pipeline succeeds, unless the MR is being rebased.
+// Otherwise the merge is added to the retries, or skipped if `retries` is nil.
+func (t Task) waitForMergeable(p pendingMerge, rmr *gitlab.MergeRequest, retries *[]pendingMerge) error {
+	if rmr.MergeWhenPipelineSucceeds {
+		// Already set to merge by an earlier run
+		return nil
+	}
+
+	// While the MR is being rebased, its head will change, so GitLab would reject the pinned SHA
+	rebasing := p.rebasePending || rmr.RebaseInProgress
+	if !rebasing && rmr.DetailedMergeStatus == client.MR_MERGE_STATUS_CI_STILL_RUNNING {
+		finish, ok, err := t.estimatedPipelineFinish(rmr)
+		if err != nil {
+			return t.client.Comment(p.mr, COMMENT_MERGE_FAILED, fmt.Sprintf("Error while estimating the pipeline duration.\n\n%s", err.Error()))
+		}
+		if ok && finish.Before(p.end) {
+			opts, err := p.mergeOptions.forMR(rmr, p.occurrences, p.scheduledAt)
+			if err != nil {
+				return t.client.Comment(p.mr, COMMENT_MERGE_FAILED, fmt.Sprintf("Error while rendering the commit message.\n\n%s", err.Error()))
+			}
+			opts.SHA = p.sha
+			err = t.client.AutoMergeMr(rmr, opts)
+			if err != nil {
+				return t.client.Comment(p.mr, COMMENT_MERGE_FAILED, fmt.Sprintf("Error while setting the MR to merge when the pipeline succeeds.\n\n%s", err.Error()))
+			}
+			t.quota.record(p.mr, p.occurrences)
+			t.autoMerges.add(p.mr, p.end)
+			return t.client.Comment(p.mr, COMMENT_MERGE_WHEN_PIPELINE_SUCCEEDS, fmt.Sprintf(
+				"The pipeline is still running and is expected to finish at %s, before the merge window ends at %s. The MR will be merged by GitLab once the pipeline succeeds.",
+				finish.In(p.end.Location()).Format(time.UnixDate),
+				p.end.Format(time.UnixDate),
+			))
+		}
+	}
+
+	if retries != nil {
+		*retries = append(*retries, p)
+		return nil
+	}
+	return t.client.Comment(p.mr, COMMENT_MERGE_SKIPPED, fmt.Sprintf("MR is not mergeable. Current status: %s", rmr.DetailedMergeStatus))
+}
+
+// estimatedPipelineFinish estimates when the head pipeline of the MR finishes, based on the duration of the last successful pipeline.
+// The second return value is false if there is no estimate, e.g. because no pipeline of the MR has succeeded yet.
+func (t Task) estimatedPipelineFinish(mr *gitlab.MergeRequest) (time.Time, bool, error) {
+	if mr.HeadPipeline == nil {
+		return time.Time{}, false, nil
+	}
+	started := mr.HeadPipeline.StartedAt
+	if started == nil {
+		started = mr.HeadPipeline.CreatedAt
+	}
+	if started == nil {
+		return time.Time{}, false, nil
+	}
+	duration, err := t.client.GetLastPipelineDuration(mr)
+	if err != nil || duration == 0 {
+		return time.Time{}, false, err
+	}
+	return started.Add(duration), true, nil
+}
+
+// retryMerges retries the given merges with exponential backoff, as long as their merge window is active.
+// Merges still blocked after the last attempt are skipped until the next run.
+func (t Task) retryMerges(pending []pendingMerge) error {
+	errs := make([]error, 0)
+	backoff := retryInitialBackoff
+	for attempt := 1; len(pending) > 0; attempt++ {
+		t.clock.Sleep(backoff)
+		backoff *= 2
+
+		retries := make([]pendingMerge, 0)
+		next := &retries
+		if attempt == retryMaxAttempts {
+			next = nil
+		}
+		for _, p := range pending {
+			if !t.clock.Now().Before(p.end) {
+				errs = append(errs, t.client.Comment(p.mr, COMMENT_MERGE_SKIPPED, "The merge window ended while waiting for the MR to become mergeable."))
+				continue
+			}
+			errs = append(errs, t.mergeMR(p, next))
+		}
+		pending = retries
+	}
+	return multierr.Combine(errs...)
+}
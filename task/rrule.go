@@ -0,0 +1,414 @@
+package task
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rruleDateFormats are the accepted formats for DTSTART, UNTIL and EXDATE values.
+// Values without an explicit offset are floating wall clock times in the schedule's location.
+var rruleDateFormats = append([]string{
+	"20060102T150405Z07:00",
+	"20060102T150405",
+	"20060102",
+}, dateFormats...)
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// rruleWeekday is an entry of BYDAY, e.g. `MO` (every monday) or `-1FR` (the last friday).
+type rruleWeekday struct {
+	ordinal int
+	weekday time.Weekday
+}
+
+// rruleSchedule is a cron.Schedule activating on the occurrences of an iCalendar recurrence rule (RFC 5545).
+// The frequencies DAILY, WEEKLY, MONTHLY and YEARLY are supported, along with the rule parts
+// INTERVAL, COUNT, UNTIL, BYMONTH, BYMONTHDAY, BYDAY, BYHOUR, BYMINUTE, BYSETPOS and WKST.
+type rruleSchedule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	byMonth    []int
+	byMonthDay []int
+	byDay      []rruleWeekday
+	byHour     []int
+	byMinute   []int
+	bySetPos   []int
+	weekStart  time.Weekday
+
+	dtstart time.Time
+	// exdates contains the excluded occurrences, exdays the days on which all occurrences are excluded
+	exdates  []time.Time
+	exdays   map[string]bool
+	location *time.Location
+}
+
+// parseRRule parses a recurrence rule like "FREQ=WEEKLY;BYDAY=MO,TH;BYHOUR=2" starting at dtstart, excluding the given exdates.
+// Dates are interpreted in the given location, the returned schedule is evaluated on the wall clock of that location.
+func parseRRule(rule string, dtstart string, exdates []string, location *time.Location) (rruleSchedule, error) {
+	s := rruleSchedule{
+		interval:  1,
+		weekStart: time.Monday,
+		exdays:    map[string]bool{},
+		// The rule is evaluated on the wall clock, see mergeSchedule
+		location: time.UTC,
+	}
+	if dtstart == "" {
+		return rruleSchedule{}, fmt.Errorf("rrule requires dtstart")
+	}
+	start, _, err := parseRRuleDate(dtstart, location)
+	if err != nil {
+		return rruleSchedule{}, fmt.Errorf("invalid dtstart: %w", err)
+	}
+	s.dtstart = start
+	for _, e := range exdates {
+		ex, dateOnly, err := parseRRuleDate(e, location)
+		if err != nil {
+			return rruleSchedule{}, fmt.Errorf("invalid exdate: %w", err)
+		}
+		if dateOnly {
+			s.exdays[ex.Format(time.DateOnly)] = true
+		} else {
+			s.exdates = append(s.exdates, ex)
+		}
+	}
+
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	for _, part := range strings.Split(rule, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return rruleSchedule{}, fmt.Errorf("invalid rrule part '%s'", part)
+		}
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			s.freq = strings.ToUpper(value)
+		case "INTERVAL":
+			s.interval, err = strconv.Atoi(value)
+			if err == nil && s.interval < 1 {
+				err = fmt.Errorf("must be at least 1")
+			}
+		case "COUNT":
+			s.count, err = strconv.Atoi(value)
+		case "UNTIL":
+			s.until, _, err = parseRRuleDate(value, location)
+		case "BYMONTH":
+			s.byMonth, err = parseRRuleInts(value, 1, 12)
+		case "BYMONTHDAY":
+			s.byMonthDay, err = parseRRuleInts(value, -31, 31)
+		case "BYHOUR":
+			s.byHour, err = parseRRuleInts(value, 0, 23)
+		case "BYMINUTE":
+			s.byMinute, err = parseRRuleInts(value, 0, 59)
+		case "BYSETPOS":
+			s.bySetPos, err = parseRRuleInts(value, -366, 366)
+		case "BYDAY":
+			s.byDay, err = parseRRuleWeekdays(value)
+		case "WKST":
+			wd, ok := rruleWeekdays[strings.ToUpper(value)]
+			if !ok {
+				err = fmt.Errorf("unknown weekday")
+			}
+			s.weekStart = wd
+		default:
+			err = fmt.Errorf("not supported")
+		}
+		if err != nil {
+			return rruleSchedule{}, fmt.Errorf("invalid rrule part '%s': %w", part, err)
+		}
+	}
+	switch s.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	case "":
+		return rruleSchedule{}, fmt.Errorf("rrule requires FREQ")
+	default:
+		return rruleSchedule{}, fmt.Errorf("unsupported rrule frequency '%s'", s.freq)
+	}
+	return s, nil
+}
+
+// Next returns the next occurrence after `t`, or the zero time if there is none.
+func (s rruleSchedule) Next(t time.Time) time.Time {
+	next, _ := s.next(t)
+	return next
+}
+
+func (s rruleSchedule) hasEnded(t time.Time) bool {
+	_, ended := s.next(t)
+	return ended
+}
+
+// next returns the next occurrence after `t`, or the zero time if there is none.
+// The returned bool is true if there is none because the rule ended by COUNT or UNTIL, and false if the search gave up.
+func (s rruleSchedule) next(t time.Time) (time.Time, bool) {
+	t = t.In(s.location)
+	period := 0
+	if s.count == 0 {
+		// Without COUNT, occurrences before `t` don't matter, so we can skip right to the period containing `t`
+		period = max(0, s.periodsUntil(t)-1)
+		period -= period % s.interval
+	}
+	n := 0
+	for i := 0; i < 10000; i++ {
+		for _, o := range s.expand(s.periodStart(period)) {
+			if o.Before(s.dtstart) {
+				continue
+			}
+			if !s.until.IsZero() && o.After(s.until) {
+				return time.Time{}, true
+			}
+			n++
+			if s.count > 0 && n > s.count {
+				return time.Time{}, true
+			}
+			if o.After(t) && !s.isExcluded(o) {
+				return o, false
+			}
+		}
+		period += s.interval
+	}
+	return time.Time{}, false
+}
+
+func (s rruleSchedule) isExcluded(t time.Time) bool {
+	if s.exdays[t.Format(time.DateOnly)] {
+		return true
+	}
+	return slices.ContainsFunc(s.exdates, t.Equal)
+}
+
+// periodStart returns the start of the n-th period after the one containing dtstart.
+func (s rruleSchedule) periodStart(n int) time.Time {
+	y, m, d := s.dtstart.Date()
+	switch s.freq {
+	case "DAILY":
+		return time.Date(y, m, d+n, 0, 0, 0, 0, s.location)
+	case "WEEKLY":
+		offset := (int(s.dtstart.Weekday()) - int(s.weekStart) + 7) % 7
+		return time.Date(y, m, d-offset+7*n, 0, 0, 0, 0, s.location)
+	case "MONTHLY":
+		return time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, s.location)
+	}
+	return time.Date(y+n, time.January, 1, 0, 0, 0, 0, s.location)
+}
+
+// periodsUntil returns the number of periods between the one containing dtstart and the one containing `t`.
+func (s rruleSchedule) periodsUntil(t time.Time) int {
+	days := int(civilDate(t.Date()).Sub(civilDate(s.dtstart.Date())).Hours() / 24)
+	switch s.freq {
+	case "DAILY":
+		return days
+	case "WEEKLY":
+		return days / 7
+	case "MONTHLY":
+		return (t.Year()-s.dtstart.Year())*12 + int(t.Month()) - int(s.dtstart.Month())
+	}
+	return t.Year() - s.dtstart.Year()
+}
+
+// expand returns the sorted occurrences within the period starting at `start`.
+func (s rruleSchedule) expand(start time.Time) []time.Time {
+	var days []time.Time
+	switch s.freq {
+	case "DAILY":
+		days = s.filterDays([]time.Time{start}, false)
+	case "WEEKLY":
+		week := daysBetween(start, start.AddDate(0, 0, 7))
+		if len(s.byDay) == 0 {
+			week = slices.DeleteFunc(week, func(d time.Time) bool { return d.Weekday() != s.dtstart.Weekday() })
+		}
+		days = s.filterDays(week, false)
+	case "MONTHLY":
+		days = s.expandMonth(start)
+	case "YEARLY":
+		months := s.byMonth
+		if len(months) == 0 && len(s.byDay) > 0 && len(s.byMonthDay) == 0 {
+			// BYDAY without BYMONTH applies to the whole year
+			days = s.filterDays(daysBetween(start, start.AddDate(1, 0, 0)), true)
+			break
+		}
+		if len(months) == 0 {
+			months = []int{int(s.dtstart.Month())}
+		}
+		for _, m := range months {
+			days = append(days, s.expandMonth(time.Date(start.Year(), time.Month(m), 1, 0, 0, 0, 0, s.location))...)
+		}
+	}
+
+	hours := s.byHour
+	if len(hours) == 0 {
+		hours = []int{s.dtstart.Hour()}
+	}
+	minutes := s.byMinute
+	if len(minutes) == 0 {
+		minutes = []int{s.dtstart.Minute()}
+	}
+	occurrences := make([]time.Time, 0, len(days)*len(hours)*len(minutes))
+	for _, d := range days {
+		for _, h := range hours {
+			for _, m := range minutes {
+				occurrences = append(occurrences, time.Date(d.Year(), d.Month(), d.Day(), h, m, s.dtstart.Second(), 0, s.location))
+			}
+		}
+	}
+	sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].Before(occurrences[j]) })
+	return s.selectSetPos(occurrences)
+}
+
+// expandMonth returns the days of the month starting at `start` selected by the rule.
+func (s rruleSchedule) expandMonth(start time.Time) []time.Time {
+	days := daysBetween(start, start.AddDate(0, 1, 0))
+	if len(s.byMonthDay) == 0 && len(s.byDay) == 0 {
+		days = slices.DeleteFunc(days, func(d time.Time) bool { return d.Day() != s.dtstart.Day() })
+	}
+	return s.filterDays(days, true)
+}
+
+// filterDays returns the days matching BYMONTH, BYMONTHDAY and BYDAY.
+// If `ordinals` is set, ordinals in BYDAY select the Nth matching weekday of the given days.
+func (s rruleSchedule) filterDays(days []time.Time, ordinals bool) []time.Time {
+	selected := make([]time.Time, 0, len(days))
+	for _, d := range days {
+		if len(s.byMonth) > 0 && !slices.Contains(s.byMonth, int(d.Month())) {
+			continue
+		}
+		if len(s.byMonthDay) > 0 && !s.matchesMonthDay(d) {
+			continue
+		}
+		if len(s.byDay) > 0 && !s.matchesDay(d, days, ordinals) {
+			continue
+		}
+		selected = append(selected, d)
+	}
+	return selected
+}
+
+func (s rruleSchedule) matchesMonthDay(d time.Time) bool {
+	daysInMonth := time.Date(d.Year(), d.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, md := range s.byMonthDay {
+		if md == d.Day() || (md < 0 && daysInMonth+md+1 == d.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s rruleSchedule) matchesDay(d time.Time, scope []time.Time, ordinals bool) bool {
+	for _, wd := range s.byDay {
+		if wd.weekday != d.Weekday() {
+			continue
+		}
+		if wd.ordinal == 0 || !ordinals {
+			return true
+		}
+		same := slices.DeleteFunc(slices.Clone(scope), func(o time.Time) bool { return o.Weekday() != wd.weekday })
+		i := wd.ordinal - 1
+		if wd.ordinal < 0 {
+			i = len(same) + wd.ordinal
+		}
+		if i >= 0 && i < len(same) && same[i].Equal(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectSetPos returns the occurrences selected by BYSETPOS.
+func (s rruleSchedule) selectSetPos(occurrences []time.Time) []time.Time {
+	if len(s.bySetPos) == 0 {
+		return occurrences
+	}
+	selected := []time.Time{}
+	for _, pos := range s.bySetPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(occurrences) + pos
+		}
+		if i >= 0 && i < len(occurrences) {
+			selected = append(selected, occurrences[i])
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Before(selected[j]) })
+	return slices.CompactFunc(selected, time.Time.Equal)
+}
+
+// daysBetween returns the days from `start`, inclusive, to `end`, exclusive.
+func daysBetween(start time.Time, end time.Time) []time.Time {
+	days := []time.Time{}
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	return days
+}
+
+func parseRRuleInts(value string, min int, max int) ([]int, error) {
+	values := []int{}
+	for _, v := range strings.Split(value, ",") {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		if i < min || i > max || i == 0 && min < 0 {
+			return nil, fmt.Errorf("%d is out of range", i)
+		}
+		values = append(values, i)
+	}
+	return values, nil
+}
+
+func parseRRuleWeekdays(value string) ([]rruleWeekday, error) {
+	weekdays := []rruleWeekday{}
+	for _, v := range strings.Split(strings.ToUpper(value), ",") {
+		if len(v) < 2 {
+			return nil, fmt.Errorf("unknown weekday '%s'", v)
+		}
+		wd, ok := rruleWeekdays[v[len(v)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday '%s'", v)
+		}
+		ordinal := 0
+		if len(v) > 2 {
+			var err error
+			ordinal, err = strconv.Atoi(v[:len(v)-2])
+			if err != nil || ordinal == 0 {
+				return nil, fmt.Errorf("invalid weekday ordinal '%s'", v)
+			}
+		}
+		weekdays = append(weekdays, rruleWeekday{ordinal: ordinal, weekday: wd})
+	}
+	return weekdays, nil
+}
+
+// parseRRuleDate parses a DTSTART, UNTIL or EXDATE value into the wall clock time of the given location, represented in UTC.
+// Floating values are taken as they are, so wall clock times which don't exist in the location, e.g. during a DST gap, aren't shifted.
+// The second return value is true if the value is a plain date.
+func parseRRuleDate(value string, location *time.Location) (time.Time, bool, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, "Z") && len(value) == len("20060102T150405Z") {
+		value = strings.TrimSuffix(value, "Z") + "+00:00"
+	}
+	for _, format := range rruleDateFormats {
+		t, err := time.Parse(format, value)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(format, "Z07") {
+			t = wallClock(t.In(location))
+		}
+		return t, format == "20060102" || format == time.DateOnly, nil
+	}
+	return time.Time{}, false, fmt.Errorf("unknown date format: '%s'", value)
+}
@@ -0,0 +1,187 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RRule(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart string
+		exdates []string
+		// after is the wall clock time from which the occurrences are listed
+		after string
+		// want are the next occurrences on the wall clock, fewer if the rule ends
+		want []string
+	}{
+		{
+			name:    "floating dtstart in DST gap",
+			rule:    "FREQ=DAILY",
+			dtstart: "20240331T023000",
+			after:   "2024-06-27T12:00",
+			want:    []string{"2024-06-28T02:30", "2024-06-29T02:30"},
+		},
+		{
+			name:    "utc dtstart",
+			rule:    "FREQ=DAILY",
+			dtstart: "20240101T090000Z",
+			after:   "2024-06-27T12:00",
+			want:    []string{"2024-06-28T10:00", "2024-06-29T10:00"},
+		},
+		{
+			name:    "interval",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU",
+			dtstart: "20240102T090000",
+			after:   "2024-01-03T00:00",
+			want:    []string{"2024-01-16T09:00", "2024-01-30T09:00", "2024-02-13T09:00"},
+		},
+		{
+			name:    "bysetpos last weekday of the month",
+			rule:    "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+			dtstart: "20240101T170000",
+			after:   "2024-06-01T00:00",
+			want:    []string{"2024-06-28T17:00", "2024-07-31T17:00", "2024-08-30T17:00"},
+		},
+		{
+			name:    "bysetpos with multiple hours",
+			rule:    "FREQ=DAILY;BYHOUR=8,12,18;BYSETPOS=1,-1",
+			dtstart: "20240101T000000",
+			after:   "2024-06-27T10:00",
+			want:    []string{"2024-06-27T18:00", "2024-06-28T08:00", "2024-06-28T18:00"},
+		},
+		{
+			name:    "negative byday",
+			rule:    "FREQ=MONTHLY;BYDAY=-1FR",
+			dtstart: "20240105T180000",
+			after:   "2024-03-01T00:00",
+			want:    []string{"2024-03-29T18:00", "2024-04-26T18:00", "2024-05-31T18:00"},
+		},
+		{
+			name:    "second to last monday",
+			rule:    "FREQ=MONTHLY;BYDAY=-2MO",
+			dtstart: "20240101T100000",
+			after:   "2024-06-01T00:00",
+			want:    []string{"2024-06-17T10:00", "2024-07-22T10:00"},
+		},
+		{
+			name:    "yearly nth weekday",
+			rule:    "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH",
+			dtstart: "20200101T120000",
+			after:   "2024-01-01T00:00",
+			want:    []string{"2024-11-28T12:00", "2025-11-27T12:00"},
+		},
+		{
+			// RFC 5545, section 3.8.5.3
+			name:    "wkst monday",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO",
+			dtstart: "19970805T090000",
+			after:   "1997-08-01T00:00",
+			want:    []string{"1997-08-05T09:00", "1997-08-10T09:00", "1997-08-19T09:00", "1997-08-24T09:00"},
+		},
+		{
+			// RFC 5545, section 3.8.5.3
+			name:    "wkst sunday",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU",
+			dtstart: "19970805T090000",
+			after:   "1997-08-01T00:00",
+			want:    []string{"1997-08-05T09:00", "1997-08-17T09:00", "1997-08-19T09:00", "1997-08-31T09:00"},
+		},
+		{
+			name:    "count",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: "20240101T100000",
+			after:   "2024-01-01T00:00",
+			want:    []string{"2024-01-01T10:00", "2024-01-02T10:00", "2024-01-03T10:00"},
+		},
+		{
+			name:    "count starting in the middle",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: "20240101T100000",
+			after:   "2024-01-02T10:00",
+			want:    []string{"2024-01-03T10:00"},
+		},
+		{
+			name:    "until is inclusive",
+			rule:    "FREQ=DAILY;UNTIL=20240103T100000",
+			dtstart: "20240101T100000",
+			after:   "2024-01-01T00:00",
+			want:    []string{"2024-01-01T10:00", "2024-01-02T10:00", "2024-01-03T10:00"},
+		},
+		{
+			name:    "utc until",
+			rule:    "FREQ=DAILY;UNTIL=20240103T085959Z",
+			dtstart: "20240101T100000",
+			after:   "2024-01-01T00:00",
+			want:    []string{"2024-01-01T10:00", "2024-01-02T10:00"},
+		},
+		{
+			name:    "exdate",
+			rule:    "FREQ=DAILY;COUNT=4",
+			dtstart: "20240101T100000",
+			exdates: []string{"20240102", "20240103T100000"},
+			after:   "2024-01-01T00:00",
+			want:    []string{"2024-01-01T10:00", "2024-01-04T10:00"},
+		},
+		{
+			name:    "exdate at other time",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: "20240101T100000",
+			exdates: []string{"20240102T110000"},
+			after:   "2024-01-01T00:00",
+			want:    []string{"2024-01-01T10:00", "2024-01-02T10:00", "2024-01-03T10:00"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := parseRRule(tc.rule, tc.dtstart, tc.exdates, zurich)
+			require.NoError(t, err)
+
+			after, err := time.Parse("2006-01-02T15:04", tc.after)
+			require.NoError(t, err)
+			got := []string{}
+			for len(got) < len(tc.want)+1 {
+				after = s.Next(after)
+				if after.IsZero() {
+					break
+				}
+				got = append(got, after.Format("2006-01-02T15:04"))
+			}
+			if len(got) > len(tc.want) {
+				got = got[:len(tc.want)]
+			}
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func Test_RRule_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		rule    string
+		dtstart string
+	}{
+		"missing dtstart":   {rule: "FREQ=DAILY"},
+		"missing freq":      {rule: "BYDAY=MO", dtstart: "20240101"},
+		"unsupported freq":  {rule: "FREQ=HOURLY", dtstart: "20240101"},
+		"zero interval":     {rule: "FREQ=DAILY;INTERVAL=0", dtstart: "20240101"},
+		"unknown part":      {rule: "FREQ=DAILY;BYWEEKNO=1", dtstart: "20240101"},
+		"unknown weekday":   {rule: "FREQ=WEEKLY;BYDAY=XX", dtstart: "20240101"},
+		"zero ordinal":      {rule: "FREQ=MONTHLY;BYDAY=0MO", dtstart: "20240101"},
+		"month day range":   {rule: "FREQ=MONTHLY;BYMONTHDAY=32", dtstart: "20240101"},
+		"invalid dtstart":   {rule: "FREQ=DAILY", dtstart: "tomorrow"},
+		"invalid wkst":      {rule: "FREQ=WEEKLY;WKST=XY", dtstart: "20240101"},
+		"part without name": {rule: "FREQ=DAILY;COUNT", dtstart: "20240101"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseRRule(tc.rule, tc.dtstart, nil, time.UTC)
+			require.Error(t, err)
+		})
+	}
+}
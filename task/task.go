@@ -1,44 +1,85 @@
 package task
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/robfig/cron/v3"
 	"github.com/vshn/gitlab-scheduled-merge/client"
 	"github.com/xanzy/go-gitlab"
 	"go.uber.org/multierr"
-	"gopkg.in/yaml.v3"
 )
 
 type TaskConfig struct {
 	MergeRequestScheduledLabel string
 	ConfigFilePath             string
+	ConfigRef                  string
+	DefaultConfigProject       string
+	DefaultConfigRef           string
+	MaxMergesPerWindow         int
 }
 
 type Task struct {
 	config TaskConfig
 	client client.GitlabClient
 	clock  Clock
+	quota  *mergeQuota
+	// autoMerges are the MRs set to merge when their pipeline succeeds
+	autoMerges *autoMerges
+	// configChanges are the MR heads known to change the config file or not
+	configChanges *configChanges
 }
 
 type RepositoryConfig struct {
-	MergeWindows []MergeWindow `yaml:"mergeWindows"`
+	Extends         string        `yaml:"extends"`
+	MergeWindows    []MergeWindow `yaml:"mergeWindows"`
+	Freezes         []Freeze      `yaml:"freezes"`
+	RequirePipeline string        `yaml:"requirePipeline"`
+	Rebase          *RebaseConfig `yaml:"rebase"`
+	MergeOptions    MergeOptions  `yaml:"mergeOptions"`
+
+	// sources describes the files this config was merged from
+	sources []string
 }
 type MergeWindow struct {
-	Schedule MergeSchedule `yaml:"schedule"`
-	MaxDelay time.Duration `yaml:"maxDelay"`
+	Name           string        `yaml:"name"`
+	Schedule       MergeSchedule `yaml:"schedule"`
+	MaxDelay       time.Duration `yaml:"maxDelay"`
+	End            MergeSchedule `yaml:"end"`
+	Hours          string        `yaml:"hours"`
+	Once           *OnceWindow   `yaml:"once"`
+	MaxMerges      int           `yaml:"maxMerges"`
+	TargetBranches []string      `yaml:"targetBranches"`
+	Paths          []string      `yaml:"paths"`
+	ExcludePaths   []string      `yaml:"excludePaths"`
 }
-type MergeSchedule struct {
-	Cron     string `yaml:"cron"`
-	IsoWeek  string `yaml:"isoWeek"`
+
+// OnceWindow is a merge window which is only active once, between two absolute timestamps.
+type OnceWindow struct {
+	From     string `yaml:"from"`
+	To       string `yaml:"to"`
 	Location string `yaml:"location"`
 }
 
+type MergeSchedule struct {
+	Cron      string          `yaml:"cron"`
+	Monthly   string          `yaml:"monthly"`
+	Weekly    string          `yaml:"weekly"`
+	RRule     string          `yaml:"rrule"`
+	DTStart   string          `yaml:"dtstart"`
+	ExDate    []string        `yaml:"exdate"`
+	IsoWeek   string          `yaml:"isoWeek"`
+	Location  string          `yaml:"location"`
+	Holidays  HolidayCalendar `yaml:"holidays"`
+	DstPolicy DstPolicy       `yaml:"dstPolicy"`
+}
+
 type Clock interface {
 	Now() time.Time
+	Sleep(d time.Duration)
 }
 
 type realClock struct{}
@@ -48,84 +89,258 @@ const (
 	COMMENT_MERGE_SKIPPED           = "Not merging automatically"
 	COMMENT_MERGE_SCHEDULING_FAILED = "Failed to schedule merge"
 	COMMENT_MERGE_SCHEDULED         = "Merge scheduled"
+
+	COMMENT_MERGE_WHEN_PIPELINE_SUCCEEDS = "Merging when pipeline succeeds"
+	COMMENT_SCHEDULED_COMMIT             = "Scheduled commit"
 )
 
 func (realClock) Now() time.Time {
 	return time.Now()
 }
 
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
 func NewTask(client client.GitlabClient, config TaskConfig) Task {
 	return Task{
-		config: config,
-		client: client,
-		clock:  realClock{},
+		config:        config,
+		client:        client,
+		clock:         realClock{},
+		quota:         newMergeQuota(),
+		autoMerges:    newAutoMerges(),
+		configChanges: newConfigChanges(),
 	}
 }
 
 func NewTaskWithClock(client client.GitlabClient, config TaskConfig, clock Clock) Task {
 	return Task{
-		config: config,
-		client: client,
-		clock:  clock,
+		config:        config,
+		client:        client,
+		clock:         clock,
+		quota:         newMergeQuota(),
+		autoMerges:    newAutoMerges(),
+		configChanges: newConfigChanges(),
 	}
 }
 
 func (t Task) Run() error {
 	log.Println("Running task...")
-	mrs, err := t.client.ListMrsWithLabel(t.config.MergeRequestScheduledLabel)
+	labeled, err := t.client.ListMrsWithLabelPrefix(t.config.MergeRequestScheduledLabel)
 	if err != nil {
 		return fmt.Errorf("failed to list MRs: %w", err)
 	}
+	mrs := make([]*gitlab.MergeRequest, 0, len(labeled))
+	for _, mr := range labeled {
+		if _, ok := t.scheduledLabelOf(mr); ok {
+			mrs = append(mrs, mr)
+		}
+	}
 
 	log.Printf("Processing %d MRs with label...\n", len(mrs))
+	t.quota.prune(t.clock.Now())
+	t.configChanges.prune(mrs)
 	errs := make([]error, 0)
-	for _, mr := range mrs {
-		err := t.processMR(mr)
+	if err := t.cancelAutoMerges(mrs); err != nil {
+		errs = append(errs, err)
+	}
+	queue, err := t.queueMRs(mrs)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	r := &taskRun{
+		configs:   t.newConfigLoader(),
+		retries:   make([]pendingMerge, 0),
+		positions: map[string]int{},
+	}
+	for _, entry := range queue {
+		err := t.processMR(entry, r)
 		if err != nil {
 			errs = append(errs, err)
 		}
 	}
+	errs = append(errs, t.retryMerges(r.retries))
 	return multierr.Combine(errs...)
 }
 
-func (t Task) processMR(mr *gitlab.MergeRequest) error {
-	file, err := t.client.GetConfigFileForMR(mr, t.config.ConfigFilePath)
+// taskRun is the state shared by the MRs processed in a single run of the task.
+type taskRun struct {
+	configs *configLoader
+	// retries are the merges blocked by a transient merge status
+	retries []pendingMerge
+	// positions counts the MRs queued for each upcoming window occurrence, by occurrencesKey
+	positions map[string]int
+}
 
+func (t Task) processMR(entry queueEntry, r *taskRun) error {
+	mr := entry.mr
+	pinnedSHA, err := t.pinnedSHA(entry)
+	if errors.Is(err, errRebaseInProgress) {
+		log.Printf("Skipping MR !%d until its rebase is done\n", mr.IID)
+		return nil
+	}
+	var rebaseFailed rebaseFailedError
+	if errors.As(err, &rebaseFailed) {
+		return t.client.Comment(mr, COMMENT_MERGE_SKIPPED, rebaseFailed.Error())
+	}
 	if err != nil {
-		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, "Missing config file.")
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while recording the scheduled commit.\n\n%s", err.Error()))
+	}
+	if pinnedSHA != mr.SHA {
+		return t.client.Comment(mr, COMMENT_MERGE_SKIPPED, t.headMovedNote(mr, pinnedSHA))
 	}
 
-	config := RepositoryConfig{}
-	err = yaml.Unmarshal(*file, &config)
+	configLocation, err := t.client.GetConfigLocationForMR(mr, t.config.ConfigRef)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while determining config location.\n\n%s", err.Error()))
+	}
 
+	config, err := r.configs.load(mr, configLocation)
 	if err != nil {
-		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while parsing config file.\n\n%s", err.Error()))
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while loading config file.\n\n%s", err.Error()))
 	}
 
-	now := t.clock.Now()
-	var earliestMergeWindow *MergeWindow = nil
-	earliestMergeWindowTime := now.Add(1000000 * time.Hour)
-	for _, w := range config.MergeWindows {
-		nextActiveStartTime, err := w.getNextActiveWindowStartTime(now)
+	freezePeriods, err := t.client.ListFreezePeriods(mr)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while fetching deploy freezes.\n\n%s", err.Error()))
+	}
+	deployFreezes := make([]Freeze, 0, len(freezePeriods))
+	for _, fp := range freezePeriods {
+		deployFreezes = append(deployFreezes, freezeFromGitlab(fp))
+	}
+	freezes := append(append([]Freeze{}, config.Freezes...), deployFreezes...)
+
+	windows, err := config.windowsForMR(mr)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while parsing merge windows.\n\n%s", err.Error()))
+	}
+	names := t.selectedWindowNames(mr)
+	windows, err = config.selectWindows(windows, names)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while selecting merge windows by label.\n\n%s", err.Error()))
+	}
+	if len(windows) == 0 && len(names) > 0 {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("The merge windows selected by label (%s) don't apply to the target branch `%s`.", describeNames(names), mr.TargetBranch))
+	}
+	if len(windows) == 0 {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("No merge window configured for target branch `%s`.", mr.TargetBranch))
+	}
+
+	var changedFiles []string
+	if hasPathFilters(windows) {
+		changedFiles, err = t.client.ListMrChangedFiles(mr)
 		if err != nil {
-			return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while parsing merge windows.\n\n%s", err.Error()))
+			return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while fetching changed files.\n\n%s", err.Error()))
 		}
-		if nextActiveStartTime.Before(now) {
-			return t.mergeMR(mr)
+	}
+	windowSets, err := windowSetsForFiles(windows, changedFiles)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while selecting merge windows for changed files.\n\n%s", err.Error()))
+	}
+
+	location, err := windows[0].location()
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while parsing merge windows.\n\n%s", err.Error()))
+	}
+	notBefore, err := t.notBefore(mr, location)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while parsing the not-before directive.\n\n%s", err.Error()))
+	}
+
+	now := t.clock.Now()
+	nextActiveStartTime, nextActiveEndTime, occurrences, quotaReached, err := t.nextMergeWindow(mr, windowSets, maxTime(now, notBefore), freezes)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while parsing merge windows.\n\n%s", err.Error()))
+	}
+	unmergedDependencies, err := t.unmergedDependencies(mr)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while checking dependencies.\n\n%s", err.Error()))
+	}
+	if !nextActiveStartTime.After(now) {
+		if len(unmergedDependencies) > 0 {
+			return t.client.Comment(mr, COMMENT_MERGE_SKIPPED, fmt.Sprintf("Waiting for dependencies to be merged: %s", strings.Join(unmergedDependencies, ", ")))
 		}
-		if nextActiveStartTime.Before(earliestMergeWindowTime) {
-			earliestMergeWindow = &w
-			earliestMergeWindowTime = nextActiveStartTime
+		if t.readsConfigFromSource() {
+			changed, err := t.changesConfig(mr, changedFiles)
+			if err != nil {
+				return t.client.Comment(mr, COMMENT_MERGE_FAILED, fmt.Sprintf("Error while fetching changed files.\n\n%s", err.Error()))
+			}
+			if changed {
+				return t.client.Comment(mr, COMMENT_MERGE_SKIPPED, t.configChangedNote())
+			}
 		}
+		return t.mergeMR(pendingMerge{
+			mr:              mr,
+			occurrences:     occurrences,
+			end:             nextActiveEndTime,
+			requirePipeline: config.RequirePipeline,
+			rebase:          config.Rebase != nil,
+			mergeOptions:    config.MergeOptions,
+			scheduledAt:     entry.scheduledAt,
+			sha:             pinnedSHA,
+		}, &r.retries)
 	}
-	nextActiveEndTime := earliestMergeWindowTime.Add(earliestMergeWindow.MaxDelay)
 
 	msg := fmt.Sprintf(
-		"This MR will be merged between %s and %s.",
-		earliestMergeWindowTime.Format(time.UnixDate),
+		"This MR will be merged between %s and %s.\n\nThe schedule was read from %s.",
+		nextActiveStartTime.Format(time.UnixDate),
 		nextActiveEndTime.Format(time.UnixDate),
+		config.describeSources(),
 	)
 
+	key := occurrencesKey(mr.ProjectID, occurrences)
+	r.positions[key]++
+	msg = fmt.Sprintf("%s\n\nThis MR is at position %d in the merge queue of this merge window (priority: %s).", msg, r.positions[key], entry.priority)
+
+	if notBefore.After(now) {
+		msg = fmt.Sprintf(
+			"%s\n\nThe merge was requested not before %s, the MR will be merged in the first merge window after that.",
+			msg,
+			notBefore.In(nextActiveStartTime.Location()).Format(time.UnixDate),
+		)
+	}
+
+	if len(unmergedDependencies) > 0 {
+		msg = fmt.Sprintf("%s\n\nThis MR will only be merged once its dependencies are merged: %s", msg, strings.Join(unmergedDependencies, ", "))
+	}
+
+	if quotaReached {
+		msg = fmt.Sprintf("%s\n\nThe maximum number of merges in the current merge window has been reached.", msg)
+	}
+
+	if rebaseAt, ok := config.rebaseStartsAt(nextActiveStartTime); ok && !now.Before(rebaseAt) && len(unmergedDependencies) == 0 {
+		rebasing, conflicts, err := t.rebaseMR(mr, pinnedSHA, entry.scheduledAt)
+		if err != nil {
+			return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while rebasing.\n\n%s", err.Error()))
+		}
+		if rebasing {
+			msg = fmt.Sprintf("%s\n\nThe MR is being rebased onto the target branch in preparation for the merge window.", msg)
+		}
+		if conflicts != "" {
+			msg = fmt.Sprintf("%s\n\nWarning: %s", msg, conflicts)
+		}
+	}
+
+	deployFreezeEnd, deployFrozen, err := activeFreezeEnd(now, deployFreezes)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while parsing deploy freezes.\n\n%s", err.Error()))
+	}
+	if deployFrozen {
+		msg = fmt.Sprintf(
+			"%s\n\nA GitLab deploy freeze is active until %s, the MR will be merged in the first merge window after it ends.",
+			msg,
+			deployFreezeEnd.Format(time.UnixDate),
+		)
+	}
+
+	changesConfig, err := t.changesConfig(mr, changedFiles)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SCHEDULING_FAILED, fmt.Sprintf("Error while fetching changed files.\n\n%s", err.Error()))
+	}
+	if changesConfig {
+		msg = fmt.Sprintf("%s\n\n%s", msg, t.configChangedNote())
+	}
+
 	if !client.IsMergeable(mr) {
 		msg = fmt.Sprintf(
 			"%s\n\nWarning: This merge request is currently not mergeable. Current status: %s",
@@ -137,78 +352,454 @@ func (t Task) processMR(mr *gitlab.MergeRequest) error {
 	return t.client.Comment(mr, COMMENT_MERGE_SCHEDULED, msg)
 }
 
-func (t Task) mergeMR(mr *gitlab.MergeRequest) error {
+// configChangedNote explains whether changes to the config file in a MR apply to the MR itself.
+func (t Task) configChangedNote() string {
+	switch t.config.ConfigRef {
+	case client.CONFIG_REF_TARGET:
+		return fmt.Sprintf("Note: This MR changes the schedule config file `%s`. The schedule is read from the target branch, so the changes only take effect once they are merged.", t.config.ConfigFilePath)
+	case client.CONFIG_REF_DEFAULT_BRANCH:
+		return fmt.Sprintf("Note: This MR changes the schedule config file `%s`. The schedule is read from the default branch, so the changes only take effect once they are merged into it.", t.config.ConfigFilePath)
+	}
+	return fmt.Sprintf("Warning: This MR changes the schedule config file `%s`. The schedule is read from the source branch, so the MR could change its own schedule and isn't merged automatically. Please merge it manually.", t.config.ConfigFilePath)
+}
+
+// readsConfigFromSource checks if the config is read from the source branch of MRs, so MRs can change their own schedule.
+func (t Task) readsConfigFromSource() bool {
+	return t.config.ConfigRef == "" || t.config.ConfigRef == client.CONFIG_REF_SOURCE
+}
+
+// nextMergeWindow returns the start and end time of the next common window of the window sets in which the MR can be merged,
+// skipping window occurrences in which the maximum number of MRs has already been merged.
+// The returned bool is true if any occurrence was skipped.
+func (t Task) nextMergeWindow(mr *gitlab.MergeRequest, sets [][]MergeWindow, now time.Time, freezes []Freeze) (time.Time, time.Time, []windowOccurrence, bool, error) {
+	quotaReached := false
+	after := now
+	for i := 0; i < 1000; i++ {
+		start, end, occurrences, err := nextCommonWindow(sets, after, freezes)
+		if err != nil {
+			return time.Time{}, time.Time{}, nil, false, err
+		}
+		exhausted := t.quota.exhaustedOccurrence(mr.ProjectID, occurrences, t.config.MaxMergesPerWindow)
+		if exhausted == nil {
+			return start, end, occurrences, quotaReached, nil
+		}
+		quotaReached = true
+		after = exhausted.end
+	}
+	return time.Time{}, time.Time{}, nil, false, fmt.Errorf("could not find a merge window with remaining merges after %s", after)
+}
+
+// mergeMR merges the MR if it is mergeable.
+// Merges blocked by a transient merge status are added to `retries`, or skipped if it is nil.
+func (t Task) mergeMR(p pendingMerge, retries *[]pendingMerge) error {
+	mr := p.mr
+	// Other MRs may have been merged in the window since it was selected, e.g. while this merge was waiting for a retry.
+	// Checking before the refresh also covers setting the MR to merge when its pipeline succeeds.
+	if t.quota.exhaustedOccurrence(mr.ProjectID, p.occurrences, t.config.MaxMergesPerWindow) != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_SKIPPED, "The maximum number of merges in the current merge window has been reached, the MR will be merged in the next merge window.")
+	}
 	// We need to recheck MRs - we might in the interim have merged other things that led to conflicts
 	rmr, err := t.client.RefreshMr(mr)
 	if err != nil {
 		return t.client.Comment(mr, COMMENT_MERGE_FAILED, fmt.Sprintf("Error while refreshing merge request data.\n\n%s", err.Error()))
 	}
 
+	if p.rebasePending && !rmr.RebaseInProgress {
+		sha, err := t.repinRebased(rmr, p.sha, p.scheduledAt)
+		if errors.Is(err, errRebaseInProgress) {
+			return t.waitForMergeable(p, rmr, retries)
+		}
+		var rebaseFailed rebaseFailedError
+		if errors.As(err, &rebaseFailed) {
+			return t.client.Comment(mr, COMMENT_MERGE_SKIPPED, rebaseFailed.Error())
+		}
+		if err != nil {
+			return t.client.Comment(mr, COMMENT_MERGE_FAILED, fmt.Sprintf("Error while recording the rebased commit.\n\n%s", err.Error()))
+		}
+		p.sha = sha
+		p.rebasePending = false
+	}
+	if p.sha != "" && rmr.SHA != p.sha && !rmr.RebaseInProgress {
+		return t.client.Comment(mr, COMMENT_MERGE_SKIPPED, t.headMovedNote(rmr, p.sha))
+	}
+	if p.rebase && !p.rebasePending {
+		rebasing, conflicts, err := t.rebaseMR(rmr, p.sha, p.scheduledAt)
+		if err != nil {
+			return t.client.Comment(mr, COMMENT_MERGE_FAILED, fmt.Sprintf("Error while rebasing.\n\n%s", err.Error()))
+		}
+		if conflicts != "" {
+			return t.client.Comment(mr, COMMENT_MERGE_SKIPPED, conflicts)
+		}
+		if rebasing {
+			// The rebased head is pinned by a retry once the rebase is done
+			p.rebasePending = p.sha != ""
+			return t.waitForMergeable(p, rmr, retries)
+		}
+	}
+	if rmr.RebaseInProgress || client.HasTransientMergeStatus(rmr) {
+		return t.waitForMergeable(p, rmr, retries)
+	}
 	if !client.IsMergeable(rmr) {
 		return t.client.Comment(mr, COMMENT_MERGE_SKIPPED, fmt.Sprintf("MR is not mergeable. Current status: %s", rmr.DetailedMergeStatus))
 	}
 
-	err = t.client.MergeMr(rmr)
+	if blocking := blockingPipeline(rmr, p.requirePipeline); blocking != "" {
+		return t.client.Comment(mr, COMMENT_MERGE_SKIPPED, blocking)
+	}
+
+	opts, err := p.mergeOptions.forMR(rmr, p.occurrences, p.scheduledAt)
+	if err != nil {
+		return t.client.Comment(mr, COMMENT_MERGE_FAILED, fmt.Sprintf("Error while rendering the commit message.\n\n%s", err.Error()))
+	}
+	opts.SHA = p.sha
+	err = t.client.MergeMr(rmr, opts)
 	if err != nil {
 		return t.client.Comment(mr, COMMENT_MERGE_FAILED, fmt.Sprintf("Error while merging.\n\n%s", err.Error()))
 	}
+	t.quota.record(mr, p.occurrences)
 
 	return nil
 }
 
-// getNextActiveWindowStartTime returns the start time of the next active merge window
-// including potential windows that have already started but are still active at timestamp `t`
-func (w MergeWindow) getNextActiveWindowStartTime(t time.Time) (time.Time, error) {
-	location := time.Local
-	if w.Schedule.Location != "" {
-		l, err := time.LoadLocation(w.Schedule.Location)
+// errScheduleEnded is returned for merge windows whose schedule has no further activations.
+var errScheduleEnded = errors.New("schedule has ended")
+
+// endingSchedule is implemented by schedules with a limited number of activations, like `once` windows or rrules with COUNT or UNTIL.
+// Next returns the zero time both if such a schedule has ended and if no activation was found within its search limit.
+type endingSchedule interface {
+	// hasEnded checks if the schedule has no activations after `t`.
+	hasEnded(t time.Time) bool
+}
+
+// hasEnded checks if the given schedule has no activations after `t` because it has ended.
+func hasEnded(sched cron.Schedule, t time.Time) bool {
+	e, ok := sched.(endingSchedule)
+	return ok && e.hasEnded(t)
+}
+
+// getNextActiveWindow returns the next active occurrence of the merge window
+// including potential windows that have already started but are still active at timestamp `t`.
+// Any time covered by one of the given freezes is excluded from the window.
+func (w MergeWindow) getNextActiveWindow(t time.Time, freezes []Freeze) (windowOccurrence, error) {
+	sched, err := w.parse()
+	if err != nil {
+		return windowOccurrence{}, err
+	}
+
+	nextRun := sched.firstStart(t)
+	after := t
+	for i := 0; i < 1000 && !nextRun.IsZero(); i++ {
+		start, end, err := clipToFreezes(maxTime(nextRun, t), sched.end(nextRun), freezes)
 		if err != nil {
-			return time.Time{}, fmt.Errorf("failed to load location for merge window: %w", err)
+			return windowOccurrence{}, err
+		}
+		if start.Before(end) {
+			return windowOccurrence{
+				window:    w,
+				scheduled: nextRun,
+				start:     start,
+				end:       end,
+			}, nil
 		}
-		location = l
+		after = nextRun
+		nextRun = sched.start.Next(nextRun)
 	}
-	now := t.In(location)
-	earliestTime := now.Add(-w.MaxDelay)
+	if nextRun.IsZero() && hasEnded(sched.start, after) {
+		return windowOccurrence{}, errScheduleEnded
+	}
+	return windowOccurrence{}, fmt.Errorf("could not find next run after %s", after)
+}
+
+// windowSchedule is the parsed schedule of a merge window.
+type windowSchedule struct {
+	// start activates at the start of each occurrence of the window
+	start cron.Schedule
+	// end returns the end of the occurrence starting at the given time
+	end func(time.Time) time.Time
+	// maxLength is the maximum duration of an occurrence, or zero if it is unknown
+	maxLength time.Duration
+}
+
+// firstStart returns the start of the earliest occurrence which may still be active at `t`.
+func (s windowSchedule) firstStart(t time.Time) time.Time {
+	if s.maxLength > 0 {
+		return s.start.Next(t.Add(-s.maxLength))
+	}
+	// Without a maximum length, search the latest start before `t` with growing lookbacks, like for freezes
+	for _, lookback := range freezeLookbacks {
+		start := s.start.Next(t.Add(-lookback))
+		if start.IsZero() || start.After(t) {
+			continue
+		}
+		for next := s.start.Next(start); !next.IsZero() && !next.After(t); next = s.start.Next(next) {
+			start = next
+		}
+		return start
+	}
+	return s.start.Next(t)
+}
 
-	sched, err := cron.ParseStandard(w.Schedule.Cron)
+// parse returns the schedule of the merge window, defined by its hours, a one-off time range, or its schedule and either a max delay or an end schedule.
+func (w MergeWindow) parse() (windowSchedule, error) {
+	if w.Once != nil {
+		if w.Hours != "" || !w.Schedule.isEmpty() || w.MaxDelay != 0 || !w.End.isEmpty() {
+			return windowSchedule{}, fmt.Errorf("once can't be combined with hours, a schedule, maxDelay or end")
+		}
+		from, to, err := parseDateRange(w.Once.From, w.Once.To, w.Once.Location, "merge window")
+		return windowSchedule{
+			start:     onceSchedule{from},
+			end:       func(time.Time) time.Time { return to },
+			maxLength: to.Sub(from),
+		}, err
+	}
+	if w.Hours == "" && w.End.isEmpty() {
+		sched, err := w.Schedule.parse()
+		return windowSchedule{
+			start:     sched,
+			end:       func(t time.Time) time.Time { return t.Add(w.MaxDelay) },
+			maxLength: w.MaxDelay,
+		}, err
+	}
+	if w.Hours == "" {
+		if w.MaxDelay != 0 {
+			return windowSchedule{}, fmt.Errorf("end can't be combined with maxDelay")
+		}
+		sched, err := w.Schedule.parse()
+		if err != nil {
+			return windowSchedule{}, err
+		}
+		endSched, err := w.End.inherit(w.Schedule).parse()
+		if err != nil {
+			return windowSchedule{}, fmt.Errorf("invalid end: %w", err)
+		}
+		return windowSchedule{
+			start: sched,
+			end:   endSched.Next,
+		}, nil
+	}
+
+	if !w.Schedule.isEmpty() || w.MaxDelay != 0 || !w.End.isEmpty() {
+		return windowSchedule{}, fmt.Errorf("hours can't be combined with a schedule, maxDelay or end")
+	}
+	location, err := w.Schedule.location()
+	if err != nil {
+		return windowSchedule{}, err
+	}
+	hours, err := parseHours(w.Hours, location)
+	if err != nil {
+		return windowSchedule{}, err
+	}
+	sched, err := w.Schedule.restrict(hours, location)
+	return windowSchedule{
+		start:     sched,
+		end:       hours.end,
+		maxLength: 24 * time.Hour,
+	}, err
+}
+
+// location returns the location in which the merge window is defined.
+func (w MergeWindow) location() (*time.Location, error) {
+	if w.Once == nil {
+		return w.Schedule.location()
+	}
+	if w.Once.Location == "" {
+		return time.Local, nil
+	}
+	l, err := time.LoadLocation(w.Once.Location)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("failed to parse cron schedule: %w", err)
+		return nil, fmt.Errorf("failed to load location for merge window: %w", err)
+	}
+	return l, nil
+}
+
+// onceSchedule is a cron.Schedule activating only once, at the given time.
+type onceSchedule struct {
+	at time.Time
+}
+
+// Next returns the activation time if it is after `t`, or the zero time otherwise.
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if s.at.After(t) {
+		return s.at
 	}
+	return time.Time{}
+}
 
-	nextRun := sched.Next(earliestTime)
-	for i := 0; i < 1000; i++ {
-		isoWeekOK, err := w.checkIsoWeek(nextRun)
+func (s onceSchedule) hasEnded(t time.Time) bool {
+	return !s.at.After(t)
+}
+
+// expression returns the configured hours or schedule expression of the merge window.
+func (w MergeWindow) expression() string {
+	if w.Once != nil {
+		return w.Once.From + " - " + w.Once.To
+	}
+	if w.Hours != "" {
+		return w.Hours
+	}
+	return w.Schedule.expression()
+}
+
+// parse returns the schedule in the configured location, restricted to the configured iso weeks.
+func (s MergeSchedule) parse() (cron.Schedule, error) {
+	location, err := s.location()
+	if err != nil {
+		return nil, err
+	}
+
+	var sched cron.Schedule
+	switch {
+	case countNonEmpty(s.Cron, s.Monthly, s.Weekly, s.RRule) > 1:
+		return nil, fmt.Errorf("only one of cron, monthly, weekly and rrule can be set in a schedule")
+	case s.Monthly != "":
+		// Like cron schedules, monthly schedules are evaluated on the wall clock, see mergeSchedule
+		sched, err = parseMonthly(s.Monthly, time.UTC, s.Holidays)
+	case s.Weekly != "":
+		sched, err = parseWeekly(s.Weekly)
+	case s.RRule != "":
+		sched, err = parseRRule(s.RRule, s.DTStart, s.ExDate, location)
 		if err != nil {
-			return time.Time{}, err
+			err = fmt.Errorf("failed to parse rrule schedule: %w", err)
 		}
-		if isoWeekOK {
-			return nextRun, nil
+	default:
+		sched, err = cron.ParseStandard(s.Cron)
+		if err != nil {
+			err = fmt.Errorf("failed to parse cron schedule: %w", err)
 		}
-		nextRun = sched.Next(nextRun)
 	}
-	return time.Time{}, fmt.Errorf("could not find next run, max time: %s", nextRun)
+	if err != nil {
+		return nil, err
+	}
+	return s.restrict(sched, location)
 }
 
-// checkIsoWeek checks if the given time is in the given iso week.
-// The iso week can be one of the following:
-// - "": every iso week
-// - "@even": every even iso week
-// - "@odd": every odd iso week
-// - "<N>": every iso week N
-func (w MergeWindow) checkIsoWeek(t time.Time) (bool, error) {
-	_, iw := t.ISOWeek()
-	switch w.Schedule.IsoWeek {
-	case "":
-		return true, nil
-	case "@even":
-		return iw%2 == 0, nil
-	case "@odd":
-		return iw%2 == 1, nil
+// location returns the configured location of the schedule, defaulting to the local time zone.
+func (s MergeSchedule) location() (*time.Location, error) {
+	if s.Location == "" {
+		return time.Local, nil
 	}
-	nw, err := strconv.ParseInt(w.Schedule.IsoWeek, 10, 64)
-	if err == nil {
-		return nw == int64(iw), nil
+	l, err := time.LoadLocation(s.Location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load location for merge window: %w", err)
 	}
+	return l, nil
+}
+
+// restrict returns the given schedule, evaluated in the given location and restricted to the configured iso weeks and holidays.
+func (s MergeSchedule) restrict(sched cron.Schedule, location *time.Location) (cron.Schedule, error) {
+	// Validate the holidays once so the schedule itself can't fail later on
+	if err := s.Holidays.validate(); err != nil {
+		return nil, err
+	}
+
+	isoWeek, err := parseIsoWeek(s.IsoWeek)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DstPolicy.validate(); err != nil {
+		return nil, err
+	}
+
+	return mergeSchedule{
+		schedule:  sched,
+		location:  location,
+		isoWeek:   isoWeek,
+		holidays:  s.Holidays,
+		dstPolicy: s.DstPolicy,
+	}, nil
+}
 
-	return false, fmt.Errorf("unknown iso week: %s", w.Schedule.IsoWeek)
+// mergeSchedule is a cron.Schedule that is evaluated in a specific location and skips
+// activations outside of the configured iso weeks or on holidays.
+// The underlying schedule is evaluated on the wall clock of the location, represented in UTC,
+// and its activations are mapped onto real times according to the DST policy.
+type mergeSchedule struct {
+	schedule  cron.Schedule
+	location  *time.Location
+	isoWeek   isoWeekMatcher
+	holidays  HolidayCalendar
+	dstPolicy DstPolicy
+}
+
+// Next returns the next activation time after `t`, or the zero time if none can be found.
+func (s mergeSchedule) Next(t time.Time) time.Time {
+	next, _ := s.next(t)
+	return next
+}
+
+func (s mergeSchedule) hasEnded(t time.Time) bool {
+	_, ended := s.next(t)
+	return ended
+}
+
+// next returns the next activation time after `t`, or the zero time if none can be found.
+// The returned bool is true if the underlying schedule has ended before an activation was found.
+func (s mergeSchedule) next(t time.Time) (time.Time, bool) {
+	t = t.In(s.location)
+	var next time.Time
+	// Around DST changes, an activation earlier on the wall clock can happen later in real time
+	after := wallClock(t).Add(-maxDstShift)
+	wall := s.schedule.Next(after)
+	for i := 0; i < 1000 && !wall.IsZero(); i++ {
+		if !next.IsZero() && wall.After(wallClock(next).Add(maxDstShift)) {
+			break
+		}
+		if s.isoWeek(wall) && !s.holidays.isHoliday(wall) {
+			for _, r := range s.dstPolicy.resolve(wall, s.location) {
+				if r.After(t) && (next.IsZero() || r.Before(next)) {
+					next = r
+				}
+			}
+		}
+		after = wall
+		wall = s.schedule.Next(wall)
+	}
+	return next, next.IsZero() && wall.IsZero() && hasEnded(s.schedule, after)
+}
+
+// inherit returns the schedule with the location and DST policy of `start` where they aren't set, for end schedules.
+func (s MergeSchedule) inherit(start MergeSchedule) MergeSchedule {
+	if s.Location == "" {
+		s.Location = start.Location
+	}
+	if s.DstPolicy == (DstPolicy{}) {
+		s.DstPolicy = start.DstPolicy
+	}
+	return s
+}
+
+// isEmpty checks if no schedule is configured.
+func (s MergeSchedule) isEmpty() bool {
+	return s.Cron == "" && s.Monthly == "" && s.Weekly == "" && s.RRule == ""
+}
+
+// expression returns the configured schedule expression.
+func (s MergeSchedule) expression() string {
+	if s.Monthly != "" {
+		return s.Monthly
+	}
+	if s.Weekly != "" {
+		return s.Weekly
+	}
+	if s.RRule != "" {
+		return s.RRule
+	}
+	return s.Cron
+}
+
+func countNonEmpty(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
 }
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/vshn/gitlab-scheduled-merge/client"
 	mock_client "github.com/vshn/gitlab-scheduled-merge/client/mock"
 	"github.com/vshn/gitlab-scheduled-merge/task"
 	"github.com/xanzy/go-gitlab"
@@ -20,24 +21,1478 @@ func (testClock) Now() time.Time {
 	return time
 }
 
+func (testClock) Sleep(time.Duration) {}
+
+// fixedClock is a clock returning the given time.
+type fixedClock string
+
+func (c fixedClock) Now() time.Time {
+	time, _ := time.Parse(time.RFC3339, string(c))
+	return time
+}
+
+func (fixedClock) Sleep(time.Duration) {}
+
+// defaultMergeOptions are the merge options used without a mergeOptions config.
+var defaultMergeOptions = client.MergeOptions{RemoveSourceBranch: true}
+
 type hasSubstr struct {
 	values []string
 }
 
-func (m hasSubstr) Matches(arg interface{}) bool {
-	sarg := arg.(string)
-	for _, s := range m.values {
-		if !strings.Contains(sarg, s) {
-			return false
-		}
+func (m hasSubstr) Matches(arg interface{}) bool {
+	sarg := arg.(string)
+	for _, s := range m.values {
+		if !strings.Contains(sarg, s) {
+			return false
+		}
+	}
+	return true
+}
+func (m hasSubstr) String() string {
+	return strings.Join(m.values, ", ")
+}
+
+func Test_RunTask(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(mrs[0], nil),
+		mock.EXPECT().MergeMr(mrs[0], defaultMergeOptions).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(inactiveMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], gomock.Not(hasSubstr{[]string{"Failed"}}), gomock.Any()).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_TimeZoneShenanigans(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(inactiveMergeWindowWithLocation(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], gomock.Not(hasSubstr{[]string{"Failed"}}), gomock.Any()).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(inactiveMergeWindowWithWeek(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], gomock.Not(hasSubstr{[]string{"Failed"}}), gomock.Any()).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_Freeze(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindowWithFreeze(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Sat Jun 29 10:00:00 CEST 2024"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindowWithRecurringFreeze(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Fri Jun 28 10:00:00 CEST 2024"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_DeployFreeze(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	freezes := []*gitlab.FreezePeriod{{
+		FreezeStart:  "0 0 * * 4",
+		FreezeEnd:    "0 0 * * 5",
+		CronTimezone: "Europe/Zurich",
+	}}
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[:1], nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(freezes, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{
+			"Fri Jun 28 10:00:00 CEST 2024",
+			"deploy freeze is active until Fri Jun 28 00:00:00 CEST 2024",
+		}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_Holidays(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[:1], nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindowWithHolidays(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".holidays.ics").Return(holidayCalendar(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Sun Jun 30 10:00:00 CEST 2024"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_ConfigChanged(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+		ConfigRef:                  client.CONFIG_REF_TARGET,
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[:1], nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], client.CONFIG_REF_TARGET).Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(inactiveMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return([]string{"README.md", ".config-file.yml"}, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{
+			"changes the schedule config file",
+			"read from the target branch",
+			"read from `.config-file.yml` on branch `feature` of project `group/project`",
+		}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_ConfigChangedFetchedOncePerHead(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+		ConfigRef:                  client.CONFIG_REF_TARGET,
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[0].SHA = "abc111"
+	scheduledAt, _ := time.Parse(time.RFC3339, "2024-06-27T10:25:00+02:00")
+	marker := "<!-- scheduled-sha: abc111 scheduled-at: 2024-06-27T08:25:00Z -->"
+	scheduled := func() {
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[:1], nil)
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(scheduledAt, nil)
+		mock.EXPECT().FindComment(mrs[0], task.COMMENT_SCHEDULED_COMMIT).Return(marker, nil)
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], client.CONFIG_REF_TARGET).Return(configLocation(), nil)
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(inactiveMergeWindow(), nil)
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil)
+	}
+	note := hasSubstr{[]string{"changes the schedule config file"}}
+	scheduled()
+	mock.EXPECT().ListMrChangedFiles(mrs[0]).Return([]string{".config-file.yml"}, nil)
+	mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, note).Return(nil)
+	require.NoError(t, subject.Run())
+
+	// The changed files of the same head aren't fetched again
+	scheduled()
+	mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, note).Return(nil)
+	require.NoError(t, subject.Run())
+
+}
+
+func Test_RunTask_ConfigChangedInSource(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[:1], nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return([]string{".config-file.yml"}, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SKIPPED, hasSubstr{[]string{
+			"changes the schedule config file",
+			"read from the source branch",
+			"isn't merged automatically",
+		}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_DefaultConfig(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+		DefaultConfigProject:       "infra/merge-config",
+		DefaultConfigRef:           "main",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[0].References = &gitlab.IssueReferences{Full: "group/project!1"}
+	defaults := client.ConfigLocation{ProjectPath: "infra/merge-config", Ref: "main"}
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(nil, gitlab.ErrNotFound),
+		mock.EXPECT().GetConfigFile(defaults, "group/.config-file.yml").Return(inactiveMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{
+			"read from `group/.config-file.yml` on branch `main` of project `infra/merge-config`",
+		}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(extendingMergeWindow(), nil),
+		mock.EXPECT().GetConfigFile(defaults, "base.yml").Return(namedMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{
+			"Thu Jun 27 20:00:00 CEST 2024",
+			"read from `.config-file.yml` on branch `feature` of project `group/project`, extending `base.yml` on branch `main` of project `infra/merge-config`",
+		}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_DefaultConfigOnlyIfNotFound(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+		DefaultConfigProject:       "infra/merge-config",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[:1], nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(nil, errors.New("502 Bad Gateway")),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULING_FAILED, hasSubstr{[]string{"Error while loading config file", "502 Bad Gateway"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_TargetBranches(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[0].TargetBranch = "release/1.0"
+	mrs[1].TargetBranch = "develop"
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowsPerTargetBranch(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Thu Jun 27 20:00:00 CEST 2024"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowsPerTargetBranch(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULING_FAILED, hasSubstr{[]string{"No merge window configured for target branch `develop`"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_Paths(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowsPerPath(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return([]string{"docs/index.md", "prod/values.yml"}, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Sun Jun 30 10:00:00 CEST 2024"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowsPerPath(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return([]string{"docs/index.md", "README.md"}, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Thu Jun 27 20:00:00 CEST 2024"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_MaxMerges(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+		MaxMergesPerWindow:         1,
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[1].DetailedMergeStatus = "mergeable"
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(mrs[0], nil),
+		mock.EXPECT().MergeMr(mrs[0], defaultMergeOptions).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{
+			"Fri Jun 28 10:00:00 CEST 2024",
+			"maximum number of merges",
+		}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_Queue(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[1].Labels = gitlab.Labels{"scheduled", "scheduled::priority::high"}
+	now := testClock{}.Now()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(now.Add(-2*time.Hour), nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(now.Add(-1*time.Hour), nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(inactiveMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"position 1", "priority: high"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(inactiveMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"position 2", "priority: normal"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_QueuePerWindow(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(inactiveMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"position 1"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(inactiveMergeWindowWithWeek(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"position 1"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_Dependencies(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[0].ProjectID = 42
+	mrs[0].Description = "Needs the migration first.\n\nDepends-On: !7, group/db!45"
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[:1], nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().GetMr("42", 7).Return(&gitlab.MergeRequest{State: "merged"}, nil),
+		mock.EXPECT().GetMr("group/db", 45).Return(&gitlab.MergeRequest{State: "opened"}, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SKIPPED, hasSubstr{[]string{"group/db!45 (opened)"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_NotBefore(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[0].Labels = gitlab.Labels{"scheduled", "scheduled::not-before::2024-06-28T12:00"}
+	mrs[1].Description = "Embargoed until the announcement.\n\nNot-Before: next week"
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"between Sat Jun 29 10:00:00 CEST 2024", "requested not before Fri Jun 28 12:00:00 CEST 2024"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULING_FAILED, hasSubstr{[]string{"unknown date format: 'next week'"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_WindowLabels(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[0].Labels = gitlab.Labels{"scheduled::weekend"}
+	mrs[1].Labels = gitlab.Labels{"scheduled::holiday", "scheduled::priority::low"}
+	priorityOnly := &gitlab.MergeRequest{IID: 3, Labels: gitlab.Labels{"scheduled::priority::high"}}
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(append(mrs, priorityOnly), nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled::weekend").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled::holiday").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(nightlyAndWeekendMergeWindows(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"between Sat Jun 29 10:00:00 CEST 2024"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(nightlyAndWeekendMergeWindows(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULING_FAILED, hasSubstr{[]string{"unknown merge window `holiday`, available merge windows: `nightly`, `weekend`"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_WindowLabelOtherBranch(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	releaseWindow := []byte(`
+mergeWindows:
+- name: 'nightly'
+  schedule:
+    cron: '0 10 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'
+- name: 'weekend'
+  targetBranches: ['release']
+  schedule:
+    cron: '0 10 * * 6'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	mrs := mrList()
+	mrs[0].TargetBranch = "main"
+	mrs[0].Labels = gitlab.Labels{"scheduled::weekend"}
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[:1], nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled::weekend").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(&releaseWindow, nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULING_FAILED, hasSubstr{[]string{"The merge windows selected by label (`weekend`) don't apply to the target branch `main`."}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_IsoWeekExpressions(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithIsoWeek("@every 3 from 2024-W03"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Mon Jul  1 10:00:00 CEST 2024"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithIsoWeek("1,20-10"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULING_FAILED, hasSubstr{[]string{"range 20-10 ends before it starts"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_ScheduleSearchExhausted(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	hourlyInWeek10 := []byte(`
+mergeWindows:
+- schedule:
+    cron: '0 * * * *'
+    isoWeek: '10'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(&hourlyInWeek10, nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULING_FAILED, hasSubstr{[]string{"could not find next run after"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(onceMergeWindows("2024-06-02T10:00", "2024-06-02T12:00"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULING_FAILED, hasSubstr{[]string{"no upcoming merge window"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_MonthlySchedule(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(monthlyMergeWindow("2nd tue 10:00"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Tue Jul  9 10:00:00 CEST 2024"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(monthlyMergeWindow("last business day 10:00"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Fri Jun 28 10:00:00 CEST 2024"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_RRuleSchedule(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(rruleMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Tue Jul  9 10:00:00 CEST 2024"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(endedRRuleAndCronMergeWindows(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Thu Jun 27 20:00:00 CEST 2024"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_Hours(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(hoursMergeWindow("Mon-Fri 08:00-17:00"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(mrs[0], nil),
+		mock.EXPECT().MergeMr(mrs[0], defaultMergeOptions).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(hoursMergeWindow("Sat 22:00-02:00"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"between Sat Jun 29 22:00:00 CEST 2024 and Sun Jun 30 02:00:00 CEST 2024"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_HoursAcrossDst(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, fixedClock("2024-10-26T12:00:00+02:00"))
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[1:], nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(hoursMergeWindow("Sun 01:00-05:00"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"between Sun Oct 27 01:00:00 CEST 2024 and Sun Oct 27 05:00:00 CET 2024"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_OnceWindow(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(onceMergeWindows("2024-06-27T10:00", "2024-06-27T12:00"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(mrs[0], nil),
+		mock.EXPECT().MergeMr(mrs[0], defaultMergeOptions).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(onceMergeWindows("2024-11-03T01:00", "2024-11-03T03:00"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"between Sun Nov  3 01:00:00 CET 2024 and Sun Nov  3 03:00:00 CET 2024"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_DstPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		clock    fixedClock
+		schedule string
+		expected string
+	}{
+		{
+			name:     "nonexistent time is skipped by default",
+			clock:    "2024-03-30T12:00:00+01:00",
+			schedule: "{location: 'Europe/Zurich', cron: '30 2 * * *'}",
+			expected: "between Mon Apr  1 02:30:00 CEST 2024",
+		},
+		{
+			name:     "nonexistent time is shifted forward",
+			clock:    "2024-03-30T12:00:00+01:00",
+			schedule: "{location: 'Europe/Zurich', cron: '30 2 * * *', dstPolicy: {nonexistent: 'shift-forward'}}",
+			expected: "between Sun Mar 31 03:30:00 CEST 2024",
+		},
+		{
+			name:     "nonexistent time of monthly schedule is skipped",
+			clock:    "2024-03-30T12:00:00+01:00",
+			schedule: "{location: 'Europe/Zurich', monthly: 'last sun 02:30', dstPolicy: {nonexistent: 'skip'}}",
+			expected: "between Sun Apr 28 02:30:00 CEST 2024",
+		},
+		{
+			name:     "ambiguous time runs both by default",
+			clock:    "2024-10-27T02:45:00+02:00",
+			schedule: "{location: 'Europe/Zurich', cron: '30 2 * * *'}",
+			expected: "between Sun Oct 27 02:30:00 CET 2024",
+		},
+		{
+			name:     "ambiguous time only runs first",
+			clock:    "2024-10-27T02:45:00+02:00",
+			schedule: "{location: 'Europe/Zurich', cron: '30 2 * * *', dstPolicy: {ambiguous: 'first'}}",
+			expected: "between Mon Oct 28 02:30:00 CET 2024",
+		},
+		{
+			name:     "ambiguous time of rrule runs both",
+			clock:    "2024-10-27T02:45:00+02:00",
+			schedule: "{location: 'Europe/Zurich', rrule: 'FREQ=DAILY', dtstart: '2024-10-01T02:30', dstPolicy: {ambiguous: 'run-both'}}",
+			expected: "between Sun Oct 27 02:30:00 CET 2024",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mctrl := gomock.NewController(t)
+			mock := mock_client.NewMockGitlabClient(mctrl)
+			config := task.TaskConfig{
+				MergeRequestScheduledLabel: "scheduled",
+				ConfigFilePath:             ".config-file.yml",
+			}
+
+			subject := task.NewTaskWithClock(mock, config, tt.clock)
+
+			mrs := mrList()
+			gomock.InOrder(
+				mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[1:], nil),
+				mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+				mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+				mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithSchedule(tt.schedule), nil),
+				mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+				mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+				mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{tt.expected}}).Return(nil),
+			)
+
+			err := subject.Run()
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_RunTask_EndSchedule(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithEnd("cron: '0 18 * * 3'", "cron: '0 12 * * 4'"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(mrs[0], nil),
+		mock.EXPECT().MergeMr(mrs[0], defaultMergeOptions).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithEnd("weekly: 'Fri 18:00'", "weekly: 'Mon 06:00'"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"between Fri Jun 28 18:00:00 CEST 2024 and Mon Jul  1 06:00:00 CEST 2024"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_EndScheduleHolidays(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	ics := []byte(strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20240701",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n"))
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs[1:], nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithEnd("weekly: 'Fri 18:00'", "weekly: 'Mon 06:00'\n    holidays:\n      file: '.holidays.ics'"), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".holidays.ics").Return(&ics, nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"between Fri Jun 28 18:00:00 CEST 2024 and Mon Jul  8 06:00:00 CEST 2024"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_RequirePipeline(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[1].DetailedMergeStatus = "mergeable"
+	failed := *mrs[0]
+	failed.HeadPipeline = &gitlab.Pipeline{ID: 12, Status: "failed", WebURL: "https://gitlab.example.com/group/project/-/pipelines/12"}
+	skipped := *mrs[1]
+	skipped.HeadPipeline = &gitlab.Pipeline{ID: 13, Status: "skipped"}
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindowRequiringPipeline("success"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(&failed, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SKIPPED, hasSubstr{[]string{"[#12](https://gitlab.example.com/group/project/-/pipelines/12) has status `failed`"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindowRequiringPipeline("success-or-skipped"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[1]).Return(&skipped, nil),
+		mock.EXPECT().MergeMr(&skipped, defaultMergeOptions).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_TransientMergeStatus(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	checking := *mrs[0]
+	checking.DetailedMergeStatus = "checking"
+	pipelineStart, _ := time.Parse(time.RFC3339, "2024-06-27T10:20:00+02:00")
+	running := *mrs[1]
+	running.DetailedMergeStatus = "ci_still_running"
+	running.HeadPipeline = &gitlab.Pipeline{ID: 12, Status: "running", StartedAt: &pipelineStart}
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(&checking, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[1]).Return(&running, nil),
+		mock.EXPECT().GetLastPipelineDuration(&running).Return(20*time.Minute, nil),
+		mock.EXPECT().AutoMergeMr(&running, defaultMergeOptions).Return(nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_WHEN_PIPELINE_SUCCEEDS, hasSubstr{[]string{"Thu Jun 27 10:40:00 CEST 2024"}}).Return(nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(mrs[0], nil),
+		mock.EXPECT().MergeMr(mrs[0], defaultMergeOptions).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_TransientMergeStatusQuota(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+		MaxMergesPerWindow:         1,
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	checking := *mrs[0]
+	checking.DetailedMergeStatus = "checking"
+	mrs[1].DetailedMergeStatus = "mergeable"
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(&checking, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[1]).Return(mrs[1], nil),
+		mock.EXPECT().MergeMr(mrs[1], defaultMergeOptions).Return(nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SKIPPED, hasSubstr{[]string{"maximum number of merges"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_CancelAutoMerge(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()[1:]
+	pipelineStart, _ := time.Parse(time.RFC3339, "2024-06-27T10:20:00+02:00")
+	running := *mrs[0]
+	running.DetailedMergeStatus = "ci_still_running"
+	running.HeadPipeline = &gitlab.Pipeline{ID: 12, Status: "running", StartedAt: &pipelineStart}
+	autoMerging := running
+	autoMerging.State = "opened"
+	autoMerging.MergeWhenPipelineSucceeds = true
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(&running, nil),
+		mock.EXPECT().GetLastPipelineDuration(&running).Return(20*time.Minute, nil),
+		mock.EXPECT().AutoMergeMr(&running, defaultMergeOptions).Return(nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_WHEN_PIPELINE_SUCCEEDS, gomock.Any()).Return(nil),
+		// The label was removed before the pipeline succeeded
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(&autoMerging, nil),
+		mock.EXPECT().CancelAutoMergeMr(&autoMerging).Return(nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_WHEN_PIPELINE_SUCCEEDS, hasSubstr{[]string{"cancelled", "label `scheduled` was removed"}}).Return(nil),
+		// The automatic merge is only cancelled once
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(nil, nil),
+	)
+
+	require.NoError(t, subject.Run())
+	require.NoError(t, subject.Run())
+	require.NoError(t, subject.Run())
+
+}
+
+func Test_RunTask_NoAutoMergeWhileRebasing(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()[:1]
+	mrs[0].SHA = "abc123"
+	pipelineStart, _ := time.Parse(time.RFC3339, "2024-06-27T10:20:00+02:00")
+	rebasing := *mrs[0]
+	rebasing.RebaseInProgress = true
+	rebasing.DetailedMergeStatus = "ci_still_running"
+	rebasing.HeadPipeline = &gitlab.Pipeline{ID: 12, Status: "running", StartedAt: &pipelineStart}
+	rebased := rebasing
+	rebased.RebaseInProgress = false
+	rebased.SHA = "fed789"
+	scheduledAt, _ := time.Parse(time.RFC3339, "2024-06-26T16:00:00+02:00")
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().FindComment(mrs[0], task.COMMENT_SCHEDULED_COMMIT).Return("**Scheduled commit**:  <!-- scheduled-sha: abc123 scheduled-at: 2024-06-26T14:00:00Z -->", nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithRebase("0 10 * * *"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		// The pipeline of the old head is running, but the MR isn't set to merge while it is being rebased
+		mock.EXPECT().RefreshMr(mrs[0]).Return(&rebasing, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(&rebased, nil),
+		mock.EXPECT().ListMrDiffVersions(&rebased).Return([]*gitlab.MergeRequestDiffVersion{
+			{ID: 2, HeadCommitSHA: "fed789", BaseCommitSHA: "0a0a0a"},
+			{ID: 1, HeadCommitSHA: "abc123", BaseCommitSHA: "0b0b0b"},
+		}, nil),
+		mock.EXPECT().Comment(&rebased, task.COMMENT_SCHEDULED_COMMIT, hasSubstr{[]string{"scheduled-sha: fed789"}}).Return(nil),
+		mock.EXPECT().GetLastPipelineDuration(&rebased).Return(20*time.Minute, nil),
+		mock.EXPECT().AutoMergeMr(&rebased, client.MergeOptions{SHA: "fed789", RemoveSourceBranch: true}).Return(nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_WHEN_PIPELINE_SUCCEEDS, gomock.Any()).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_Rebase(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[0].DetailedMergeStatus = "need_rebase"
+	mrs[1].DetailedMergeStatus = "need_rebase"
+	running := *mrs[1]
+	running.DetailedMergeStatus = "ci_still_running"
+	merged := *mrs[1]
+	merged.DetailedMergeStatus = "mergeable"
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithRebase("0 11 * * *"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RebaseMr(mrs[0]).Return(nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Thu Jun 27 11:00:00 CEST 2024", "is being rebased"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithRebase("0 10 * * *"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[1]).Return(mrs[1], nil),
+		mock.EXPECT().RebaseMr(mrs[1]).Return(nil),
+		mock.EXPECT().RefreshMr(mrs[1]).Return(&running, nil),
+		mock.EXPECT().RefreshMr(mrs[1]).Return(&merged, nil),
+		mock.EXPECT().MergeMr(&merged, defaultMergeOptions).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_RebasePending(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()[:1]
+	mrs[0].SHA = "abc123"
+	mrs[0].DetailedMergeStatus = "need_rebase"
+	rebasing := *mrs[0]
+	rebasing.RebaseInProgress = true
+	rebased := *mrs[0]
+	rebased.SHA = "fed789"
+	rebased.DetailedMergeStatus = "ci_still_running"
+	relisted := []*gitlab.MergeRequest{&rebased}
+	scheduledAt, _ := time.Parse(time.RFC3339, "2024-06-26T16:00:00+02:00")
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().FindComment(mrs[0], task.COMMENT_SCHEDULED_COMMIT).Return("**Scheduled commit**:  <!-- scheduled-sha: abc123 scheduled-at: 2024-06-26T14:00:00Z -->", nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithRebase("0 11 * * *"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RebaseMr(mrs[0]).Return(nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_SCHEDULED_COMMIT, hasSubstr{[]string{"<!-- scheduled-sha: abc123 scheduled-at: 2024-06-26T14:00:00Z rebase-pending -->"}}).Return(nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"is being rebased"}}).Return(nil),
+		// The MR is skipped while the rebase is running
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().FindComment(mrs[0], task.COMMENT_SCHEDULED_COMMIT).Return("**Scheduled commit**:  <!-- scheduled-sha: abc123 scheduled-at: 2024-06-26T14:00:00Z rebase-pending -->", nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(&rebasing, nil),
+		// Once the rebase is done, the rebased head is pinned instead of blocking the MR
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(relisted, nil),
+		mock.EXPECT().GetLabelAddedTime(&rebased, "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().FindComment(&rebased, task.COMMENT_SCHEDULED_COMMIT).Return("**Scheduled commit**:  <!-- scheduled-sha: abc123 scheduled-at: 2024-06-26T14:00:00Z rebase-pending -->", nil),
+		mock.EXPECT().RefreshMr(&rebased).Return(&rebased, nil),
+		mock.EXPECT().ListMrDiffVersions(&rebased).Return([]*gitlab.MergeRequestDiffVersion{
+			{ID: 2, HeadCommitSHA: "fed789", BaseCommitSHA: "0a0a0a"},
+			{ID: 1, HeadCommitSHA: "abc123", BaseCommitSHA: "0b0b0b"},
+		}, nil),
+		mock.EXPECT().Comment(&rebased, task.COMMENT_SCHEDULED_COMMIT, hasSubstr{[]string{"<!-- scheduled-sha: fed789 scheduled-at: 2024-06-26T14:00:00Z -->"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(&rebased, "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithRebase("0 11 * * *"), nil),
+		mock.EXPECT().ListFreezePeriods(&rebased).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(&rebased).Return(nil, nil),
+		mock.EXPECT().Comment(&rebased, task.COMMENT_MERGE_SCHEDULED, gomock.Not(hasSubstr{[]string{"New commits were pushed"}})).Return(nil),
+	)
+
+	require.NoError(t, subject.Run())
+	require.NoError(t, subject.Run())
+	require.NoError(t, subject.Run())
+
+}
+
+func Test_RunTask_RebasePendingPushedAfterRebase(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
 	}
-	return true
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()[:1]
+	mrs[0].SHA = "ccc999"
+	scheduledAt, _ := time.Parse(time.RFC3339, "2024-06-26T16:00:00+02:00")
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().FindComment(mrs[0], task.COMMENT_SCHEDULED_COMMIT).Return("**Scheduled commit**:  <!-- scheduled-sha: abc123 scheduled-at: 2024-06-26T14:00:00Z rebase-pending -->", nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(mrs[0], nil),
+		// A commit was pushed after the rebase, so the new head isn't the result of the rebase
+		mock.EXPECT().ListMrDiffVersions(mrs[0]).Return([]*gitlab.MergeRequestDiffVersion{
+			{ID: 3, HeadCommitSHA: "ccc999", BaseCommitSHA: "0a0a0a"},
+			{ID: 2, HeadCommitSHA: "fed789", BaseCommitSHA: "0a0a0a"},
+			{ID: 1, HeadCommitSHA: "abc123", BaseCommitSHA: "0b0b0b"},
+		}, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_SCHEDULED_COMMIT, hasSubstr{[]string{"<!-- scheduled-sha: abc123 scheduled-at: 2024-06-26T14:00:00Z -->"}}).Return(nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SKIPPED, hasSubstr{[]string{"scheduled at commit abc123", "now at commit ccc999", "re-add the label `scheduled`"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
 }
-func (m hasSubstr) String() string {
-	return strings.Join(m.values, ", ")
+
+func Test_RunTask_RebaseFailed(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()[:1]
+	mrs[0].SHA = "abc123"
+	mrs[0].TargetBranch = "main"
+	failed := *mrs[0]
+	failed.DetailedMergeStatus = "need_rebase"
+	failed.MergeError = "Rebase failed: Rebase locally, resolve all conflicts, then push the branch."
+	scheduledAt, _ := time.Parse(time.RFC3339, "2024-06-26T16:00:00+02:00")
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().FindComment(mrs[0], task.COMMENT_SCHEDULED_COMMIT).Return("**Scheduled commit**:  <!-- scheduled-sha: abc123 scheduled-at: 2024-06-26T14:00:00Z rebase-pending -->", nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(&failed, nil),
+		mock.EXPECT().Comment(&failed, task.COMMENT_SCHEDULED_COMMIT, hasSubstr{[]string{"<!-- scheduled-sha: abc123 scheduled-at: 2024-06-26T14:00:00Z -->"}}).Return(nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SKIPPED, hasSubstr{[]string{"rebase onto the target branch `main` failed: Rebase failed", "rebase the MR manually"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
 }
 
-func Test_RunTask(t *testing.T) {
+func Test_RunTask_RebaseConflicts(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[0].HasConflicts = true
+	mrs[1].DetailedMergeStatus = "conflict"
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithRebase("0 11 * * *"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SCHEDULED, hasSubstr{[]string{"Warning: The MR has conflicts with the target branch"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithRebase("0 10 * * *"), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[1]).Return(mrs[1], nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SKIPPED, hasSubstr{[]string{"can't be rebased automatically"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.NoError(t, err)
+
+}
+
+func Test_RunTask_MergeOptions(t *testing.T) {
 	mctrl := gomock.NewController(t)
 	mock := mock_client.NewMockGitlabClient(mctrl)
 	config := task.TaskConfig{
@@ -48,13 +1503,29 @@ func Test_RunTask(t *testing.T) {
 	subject := task.NewTaskWithClock(mock, config, testClock{})
 
 	mrs := mrList()
+	mrs[0].Title = "Update dependencies"
+	mrs[1].DetailedMergeStatus = "mergeable"
+	scheduledAt, _ := time.Parse(time.RFC3339, "2024-06-26T16:00:00+02:00")
 	gomock.InOrder(
-		mock.EXPECT().ListMrsWithLabel(gomock.Any()).Return(mrs, nil),
-		mock.EXPECT().GetConfigFileForMR(mrs[0], ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithMergeOptions(`{{.Title}} (!{{.IID}}, merged in {{.Window}}, scheduled on {{.ScheduledAt.Format "2006-01-02"}})`), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
 		mock.EXPECT().RefreshMr(mrs[0]).Return(mrs[0], nil),
-		mock.EXPECT().MergeMr(mrs[0]).Return(nil),
-		mock.EXPECT().GetConfigFileForMR(mrs[1], ".config-file.yml").Return(inactiveMergeWindow(), nil),
-		mock.EXPECT().Comment(mrs[1], gomock.Not(hasSubstr{[]string{"Failed"}}), gomock.Any()).Return(nil),
+		mock.EXPECT().MergeMr(mrs[0], client.MergeOptions{
+			Squash:              gitlab.Ptr(true),
+			RemoveSourceBranch:  false,
+			SquashCommitMessage: "Update dependencies (!1, merged in daily, scheduled on 2024-06-26)",
+		}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(mergeWindowWithMergeOptions(`{{.Unknown}}`), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[1]).Return(mrs[1], nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_FAILED, hasSubstr{[]string{"Error while rendering the commit message", "squashCommitMessage"}}).Return(nil),
 	)
 
 	err := subject.Run()
@@ -63,7 +1534,7 @@ func Test_RunTask(t *testing.T) {
 
 }
 
-func Test_RunTask_TimeZoneShenanigans(t *testing.T) {
+func Test_RunTask_PinnedSHA(t *testing.T) {
 	mctrl := gomock.NewController(t)
 	mock := mock_client.NewMockGitlabClient(mctrl)
 	config := task.TaskConfig{
@@ -74,12 +1545,24 @@ func Test_RunTask_TimeZoneShenanigans(t *testing.T) {
 	subject := task.NewTaskWithClock(mock, config, testClock{})
 
 	mrs := mrList()
+	mrs[0].SHA = "abc123"
+	mrs[1].SHA = "def456"
+	scheduledAt, _ := time.Parse(time.RFC3339, "2024-06-26T16:00:00+02:00")
 	gomock.InOrder(
-		mock.EXPECT().ListMrsWithLabel(gomock.Any()).Return(mrs, nil),
-		mock.EXPECT().GetConfigFileForMR(mrs[0], ".config-file.yml").Return(inactiveMergeWindowWithLocation(), nil),
-		mock.EXPECT().Comment(mrs[0], gomock.Not(hasSubstr{[]string{"Failed"}}), gomock.Any()).Return(nil),
-		mock.EXPECT().GetConfigFileForMR(mrs[1], ".config-file.yml").Return(inactiveMergeWindowWithWeek(), nil),
-		mock.EXPECT().Comment(mrs[1], gomock.Not(hasSubstr{[]string{"Failed"}}), gomock.Any()).Return(nil),
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().FindComment(mrs[0], task.COMMENT_SCHEDULED_COMMIT).Return("**Scheduled commit**:  <!-- scheduled-sha: 01d5a0 scheduled-at: 2024-06-20T08:00:00Z -->", nil),
+		mock.EXPECT().GetMrHeadAt(mrs[0], scheduledAt).Return("abc123", nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_SCHEDULED_COMMIT, hasSubstr{[]string{"<!-- scheduled-sha: abc123 scheduled-at: 2024-06-26T14:00:00Z -->"}}).Return(nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(activeMergeWindow(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[0]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[0]).Return(nil, nil),
+		mock.EXPECT().RefreshMr(mrs[0]).Return(mrs[0], nil),
+		mock.EXPECT().MergeMr(mrs[0], client.MergeOptions{SHA: "abc123", RemoveSourceBranch: true}).Return(nil),
+		mock.EXPECT().FindComment(mrs[1], task.COMMENT_SCHEDULED_COMMIT).Return("**Scheduled commit**:  <!-- scheduled-sha: abc999 scheduled-at: 2024-06-26T14:00:00Z -->", nil),
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SKIPPED, hasSubstr{[]string{"scheduled at commit abc999", "now at commit def456", "re-add the label `scheduled`"}}).Return(nil),
 	)
 
 	err := subject.Run()
@@ -88,6 +1571,39 @@ func Test_RunTask_TimeZoneShenanigans(t *testing.T) {
 
 }
 
+func Test_RunTask_PinnedSHAAtScheduling(t *testing.T) {
+	mctrl := gomock.NewController(t)
+	mock := mock_client.NewMockGitlabClient(mctrl)
+	config := task.TaskConfig{
+		MergeRequestScheduledLabel: "scheduled",
+		ConfigFilePath:             ".config-file.yml",
+	}
+
+	subject := task.NewTaskWithClock(mock, config, testClock{})
+
+	mrs := mrList()
+	mrs[0].SHA = "bad000"
+	mrs[1].SHA = "def456"
+	scheduledAt, _ := time.Parse(time.RFC3339, "2024-06-27T10:25:00+02:00")
+	gomock.InOrder(
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(scheduledAt, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, errors.New("502 Bad Gateway")),
+		// Without the time the MR was scheduled, no commit is pinned and the MR isn't merged
+		mock.EXPECT().Comment(mrs[1], task.COMMENT_MERGE_SCHEDULING_FAILED, hasSubstr{[]string{"502 Bad Gateway"}}).Return(nil),
+		// Commits pushed between labeling and the first run aren't pinned
+		mock.EXPECT().FindComment(mrs[0], task.COMMENT_SCHEDULED_COMMIT).Return("", nil),
+		mock.EXPECT().GetMrHeadAt(mrs[0], scheduledAt).Return("abc111", nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_SCHEDULED_COMMIT, hasSubstr{[]string{"<!-- scheduled-sha: abc111 scheduled-at: 2024-06-27T08:25:00Z -->"}}).Return(nil),
+		mock.EXPECT().Comment(mrs[0], task.COMMENT_MERGE_SKIPPED, hasSubstr{[]string{"scheduled at commit abc111", "now at commit bad000"}}).Return(nil),
+	)
+
+	err := subject.Run()
+
+	require.ErrorContains(t, err, "failed to determine when MR !2 was scheduled")
+
+}
+
 func Test_RunTask_WithError(t *testing.T) {
 	mctrl := gomock.NewController(t)
 	mock := mock_client.NewMockGitlabClient(mctrl)
@@ -100,10 +1616,16 @@ func Test_RunTask_WithError(t *testing.T) {
 
 	mrs := mrList()
 	gomock.InOrder(
-		mock.EXPECT().ListMrsWithLabel(gomock.Any()).Return(mrs, nil),
-		mock.EXPECT().GetConfigFileForMR(mrs[0], ".config-file.yml").Return(nil, errors.New("ERROR FAIL HALP")),
+		mock.EXPECT().ListMrsWithLabelPrefix("scheduled").Return(mrs, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[0], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetLabelAddedTime(mrs[1], "scheduled").Return(time.Time{}, nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[0], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(nil, errors.New("ERROR FAIL HALP")),
 		mock.EXPECT().Comment(mrs[0], hasSubstr{[]string{"Failed"}}, gomock.Any()).Return(nil),
-		mock.EXPECT().GetConfigFileForMR(mrs[1], ".config-file.yml").Return(inactiveMergeWindowWithWeek(), nil),
+		mock.EXPECT().GetConfigLocationForMR(mrs[1], "").Return(configLocation(), nil),
+		mock.EXPECT().GetConfigFile(configLocation(), ".config-file.yml").Return(inactiveMergeWindowWithWeek(), nil),
+		mock.EXPECT().ListFreezePeriods(mrs[1]).Return(nil, nil),
+		mock.EXPECT().ListMrChangedFiles(mrs[1]).Return(nil, nil),
 		mock.EXPECT().Comment(mrs[1], gomock.Not(hasSubstr{[]string{"Failed"}}), gomock.Any()).Return(errors.New("COMMENT FAILED")),
 	)
 
@@ -117,13 +1639,23 @@ func mrList() []*gitlab.MergeRequest {
 	f := &gitlab.MergeRequest{
 		IID:                 1,
 		DetailedMergeStatus: "mergeable",
+		Labels:              gitlab.Labels{"scheduled"},
 	}
 	g := &gitlab.MergeRequest{
-		IID: 2,
+		IID:    2,
+		Labels: gitlab.Labels{"scheduled"},
 	}
 	return []*gitlab.MergeRequest{f, g}
 }
 
+func configLocation() client.ConfigLocation {
+	return client.ConfigLocation{
+		ProjectID:   1,
+		ProjectPath: "group/project",
+		Ref:         "feature",
+	}
+}
+
 func activeMergeWindow() *[]byte {
 	yaml := []byte(`
 mergeWindows:
@@ -134,6 +1666,127 @@ mergeWindows:
 	return &yaml
 }
 
+func activeMergeWindowWithFreeze() *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule:
+    cron: '0 10 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'
+freezes:
+- from: '2024-06-27'
+  to: '2024-06-28'
+  location: 'Europe/Zurich'`)
+	return &yaml
+}
+
+func activeMergeWindowWithRecurringFreeze() *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule:
+    cron: '0 10 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'
+freezes:
+- schedule:
+    cron: '0 0 * * 4'
+    location: 'Europe/Zurich'
+  duration: '24h'`)
+	return &yaml
+}
+
+func activeMergeWindowWithHolidays() *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule:
+    cron: '0 10 * * *'
+    location: 'Europe/Zurich'
+    holidays:
+      file: '.holidays.ics'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
+func holidayCalendar() *[]byte {
+	ics := []byte(strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VEVENT",
+		"SUMMARY:Team off",
+		"DTSTART;VALUE=DATE:20240627",
+		"DTEND;VALUE=DATE:20240629",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:Anniversary",
+		"DTSTART;VALUE=DATE:20200629",
+		"RRULE:FREQ=YEARLY",
+		"EXDATE;VALUE=DATE:20210629",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n"))
+	return &ics
+}
+
+func namedMergeWindow() *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- name: 'nightly'
+  schedule:
+    cron: '0 10 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
+func extendingMergeWindow() *[]byte {
+	yaml := []byte(`
+extends: 'base.yml'
+mergeWindows:
+- name: 'nightly'
+  schedule:
+    cron: '0 20 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
+func mergeWindowsPerTargetBranch() *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule:
+    cron: '0 10 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'
+  targetBranches: ['main', 'master']
+- schedule:
+    cron: '0 20 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'
+  targetBranches: ['release/*']`)
+	return &yaml
+}
+
+func mergeWindowsPerPath() *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule:
+    cron: '0 20 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'
+- schedule:
+    cron: '0 10 * * 0'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'
+  paths: ['prod/**']
+- schedule:
+    cron: '* * * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1m'
+  paths: ['docs/**']
+  excludePaths: ['docs/**/*.yml']`)
+	return &yaml
+}
+
 func inactiveMergeWindowWithLocation() *[]byte {
 	yaml := []byte(`
 mergeWindows:
@@ -155,6 +1808,153 @@ mergeWindows:
 	return &yaml
 }
 
+func mergeWindowWithIsoWeek(isoWeek string) *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule:
+    cron: '0 10 * * *'
+    isoWeek: '` + isoWeek + `'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
+func monthlyMergeWindow(monthly string) *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule:
+    monthly: '` + monthly + `'
+    location: 'Europe/Zurich'
+    holidays:
+      region: 'CH'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
+func rruleMergeWindow() *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule:
+    rrule: 'FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH;BYHOUR=10;BYMINUTE=0'
+    dtstart: '20240611T100000'
+    exdate: ['20240627T100000']
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
+func endedRRuleAndCronMergeWindows() *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule:
+    rrule: 'FREQ=DAILY;COUNT=2'
+    dtstart: '2024-06-03T10:00'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'
+- schedule:
+    cron: '0 20 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
+func hoursMergeWindow(hours string) *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- hours: '` + hours + `'
+  schedule:
+    location: 'Europe/Zurich'`)
+	return &yaml
+}
+
+// onceMergeWindows returns a config with a past one-off merge window and one between `from` and `to`.
+func onceMergeWindows(from string, to string) *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- once:
+    from: '2024-06-01T01:00'
+    to: '2024-06-01T03:00'
+    location: 'Europe/Zurich'
+- once:
+    from: '` + from + `'
+    to: '` + to + `'
+    location: 'Europe/Zurich'`)
+	return &yaml
+}
+
+func mergeWindowWithSchedule(schedule string) *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule: ` + schedule + `
+  maxDelay: '10m'`)
+	return &yaml
+}
+
+func nightlyAndWeekendMergeWindows() *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- name: 'nightly'
+  schedule:
+    cron: '0 10 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'
+- name: 'weekend'
+  schedule:
+    cron: '0 10 * * 6'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
+func mergeWindowWithEnd(start string, end string) *[]byte {
+	yaml := []byte(`
+mergeWindows:
+- schedule:
+    ` + start + `
+    location: 'Europe/Zurich'
+  end:
+    ` + end)
+	return &yaml
+}
+
+func activeMergeWindowRequiringPipeline(requirement string) *[]byte {
+	yaml := []byte(`
+requirePipeline: '` + requirement + `'
+mergeWindows:
+- schedule:
+    cron: '0 10 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
+func mergeWindowWithRebase(cron string) *[]byte {
+	yaml := []byte(`
+rebase:
+  leadTime: '1h'
+mergeWindows:
+- schedule:
+    cron: '` + cron + `'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
+func mergeWindowWithMergeOptions(squashCommitMessage string) *[]byte {
+	yaml := []byte(`
+mergeOptions:
+  squash: true
+  removeSourceBranch: false
+  squashCommitMessage: '` + squashCommitMessage + `'
+mergeWindows:
+- name: daily
+  schedule:
+    cron: '0 10 * * *'
+    location: 'Europe/Zurich'
+  maxDelay: '1h'`)
+	return &yaml
+}
+
 func inactiveMergeWindow() *[]byte {
 	yaml := []byte(`
 mergeWindows:
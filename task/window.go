@@ -0,0 +1,264 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// windowsForMR returns the merge windows which apply to the given MR.
+func (c RepositoryConfig) windowsForMR(mr *gitlab.MergeRequest) ([]MergeWindow, error) {
+	windows := make([]MergeWindow, 0, len(c.MergeWindows))
+	for _, w := range c.MergeWindows {
+		ok, err := w.appliesToTargetBranch(mr.TargetBranch)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			windows = append(windows, w)
+		}
+	}
+	return windows, nil
+}
+
+// selectWindows returns the given windows with one of the given names, or all given windows if there are no names.
+// An error is returned if the config doesn't contain a window with one of the names.
+func (c RepositoryConfig) selectWindows(windows []MergeWindow, names []string) ([]MergeWindow, error) {
+	if len(names) == 0 {
+		return windows, nil
+	}
+	for _, n := range names {
+		if indexOfWindow(c.MergeWindows, n) < 0 {
+			return nil, fmt.Errorf("unknown merge window `%s`, available merge windows: %s", n, c.describeWindowNames())
+		}
+	}
+	selected := make([]MergeWindow, 0, len(windows))
+	for _, w := range windows {
+		if slices.Contains(names, w.Name) {
+			selected = append(selected, w)
+		}
+	}
+	return selected, nil
+}
+
+// describeWindowNames returns a human readable list of the names of the configured merge windows.
+func (c RepositoryConfig) describeWindowNames() string {
+	names := []string{}
+	for _, w := range c.MergeWindows {
+		if w.Name != "" {
+			names = append(names, w.Name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return describeNames(names)
+}
+
+// describeNames returns a human readable list of the given names.
+func describeNames(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, n := range names {
+		quoted = append(quoted, fmt.Sprintf("`%s`", n))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// appliesToTargetBranch checks if the merge window applies to MRs targeting the given branch.
+// Windows without target branches apply to all branches.
+func (w MergeWindow) appliesToTargetBranch(branch string) (bool, error) {
+	if len(w.TargetBranches) == 0 {
+		return true, nil
+	}
+	for _, pattern := range w.TargetBranches {
+		ok, err := path.Match(pattern, branch)
+		if err != nil {
+			return false, fmt.Errorf("invalid target branch pattern '%s': %w", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hasPathFilter checks if the merge window only applies to some files.
+func (w MergeWindow) hasPathFilter() bool {
+	return len(w.Paths) > 0 || len(w.ExcludePaths) > 0
+}
+
+func hasPathFilters(windows []MergeWindow) bool {
+	return slices.ContainsFunc(windows, MergeWindow.hasPathFilter)
+}
+
+// appliesToFile checks if a merge window with a path filter applies to the given file.
+func (w MergeWindow) appliesToFile(file string) (bool, error) {
+	included := len(w.Paths) == 0
+	for _, pattern := range w.Paths {
+		ok, err := matchPath(pattern, file)
+		if err != nil {
+			return false, err
+		}
+		included = included || ok
+	}
+	for _, pattern := range w.ExcludePaths {
+		ok, err := matchPath(pattern, file)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return false, nil
+		}
+	}
+	return included, nil
+}
+
+// windowSetsForFiles groups the merge windows by the changed files they apply to.
+// Each file is covered by the windows with a path filter matching it, or by the windows without path filter if there are none.
+// A MR can only be merged while at least one window of every returned set is active.
+func windowSetsForFiles(windows []MergeWindow, files []string) ([][]MergeWindow, error) {
+	general := make([]MergeWindow, 0, len(windows))
+	for _, w := range windows {
+		if !w.hasPathFilter() {
+			general = append(general, w)
+		}
+	}
+	if len(files) == 0 || len(general) == len(windows) {
+		if len(general) == 0 {
+			return nil, fmt.Errorf("no merge window without path filter configured")
+		}
+		return [][]MergeWindow{general}, nil
+	}
+
+	sets := map[string][]MergeWindow{}
+	keys := []string{}
+	for _, f := range files {
+		set := []MergeWindow{}
+		key := ""
+		for i, w := range windows {
+			if !w.hasPathFilter() {
+				continue
+			}
+			ok, err := w.appliesToFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				set = append(set, w)
+				key = fmt.Sprintf("%s,%d", key, i)
+			}
+		}
+		if len(set) == 0 {
+			if len(general) == 0 {
+				return nil, fmt.Errorf("no merge window configured for changed file `%s`", f)
+			}
+			set = general
+		}
+		if _, ok := sets[key]; !ok {
+			sets[key] = set
+			keys = append(keys, key)
+		}
+	}
+
+	result := make([][]MergeWindow, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, sets[k])
+	}
+	return result, nil
+}
+
+// matchPath checks if the file path matches the glob pattern.
+// In addition to the syntax of path.Match, a `**` element matches any number of directories.
+func matchPath(pattern string, file string) (bool, error) {
+	ok, err := matchPathElements(strings.Split(pattern, "/"), strings.Split(file, "/"))
+	if err != nil {
+		return false, fmt.Errorf("invalid path pattern '%s': %w", pattern, err)
+	}
+	return ok, nil
+}
+
+func matchPathElements(pattern []string, file []string) (bool, error) {
+	if len(pattern) == 0 {
+		return len(file) == 0, nil
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(file); i++ {
+			ok, err := matchPathElements(pattern[1:], file[i:])
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	if len(file) == 0 {
+		return false, nil
+	}
+	ok, err := path.Match(pattern[0], file[0])
+	if err != nil || !ok {
+		return false, err
+	}
+	return matchPathElements(pattern[1:], file[1:])
+}
+
+// windowOccurrence is a single occurrence of a merge window.
+type windowOccurrence struct {
+	window MergeWindow
+	// scheduled is the time at which the window's schedule activated
+	scheduled time.Time
+	// start and end limit the time in which the window is active, excluding freezes
+	start time.Time
+	end   time.Time
+}
+
+// nextActiveWindow returns the earliest next active occurrence of the given windows.
+func nextActiveWindow(windows []MergeWindow, t time.Time, freezes []Freeze) (windowOccurrence, error) {
+	var next windowOccurrence
+	for _, w := range windows {
+		o, err := w.getNextActiveWindow(t, freezes)
+		if errors.Is(err, errScheduleEnded) {
+			continue
+		}
+		if err != nil {
+			return windowOccurrence{}, err
+		}
+		if next.start.IsZero() || o.start.Before(next.start) || (o.start.Equal(next.start) && o.end.After(next.end)) {
+			next = o
+		}
+	}
+	if next.start.IsZero() {
+		return windowOccurrence{}, fmt.Errorf("no upcoming merge window")
+	}
+	return next, nil
+}
+
+// nextCommonWindow returns the start and end time of the next period in which a window of each of the given sets is active,
+// as well as the window occurrences which are active in that period.
+func nextCommonWindow(sets [][]MergeWindow, t time.Time, freezes []Freeze) (time.Time, time.Time, []windowOccurrence, error) {
+	candidate := t
+	for i := 0; i < 1000; i++ {
+		latestStart := candidate
+		var earliestEnd time.Time
+		occurrences := make([]windowOccurrence, 0, len(sets))
+		for _, set := range sets {
+			o, err := nextActiveWindow(set, candidate, freezes)
+			if err != nil {
+				return time.Time{}, time.Time{}, nil, err
+			}
+			latestStart = maxTime(latestStart, o.start)
+			if earliestEnd.IsZero() || o.end.Before(earliestEnd) {
+				earliestEnd = o.end
+			}
+			occurrences = append(occurrences, o)
+		}
+		if latestStart.Equal(candidate) {
+			return candidate, earliestEnd, occurrences, nil
+		}
+		candidate = latestStart
+	}
+	return time.Time{}, time.Time{}, nil, fmt.Errorf("could not find a time at which merge windows for all changed files are active")
+}